@@ -0,0 +1,164 @@
+package mp4meta
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	// register the decoder for the most common cover art format
+	_ "image/jpeg"
+)
+
+// Data types of the covr atom.
+const (
+	ArtworkTypeJPEG = 13
+	ArtworkTypePNG  = 14
+	ArtworkTypeBMP  = 27
+)
+
+// Artwork is a cover art image as stored in a data box of the covr atom.
+type Artwork struct {
+	Type uint32
+	Data []byte
+}
+
+// NewArtwork returns the artwork for an encoded image, with the data type
+// taken from the image format.
+func NewArtwork(data []byte) Artwork {
+	return Artwork{Type: artworkType(data), Data: data}
+}
+
+// GetArtworks returns all cover art images in file order. The first one is
+// the front cover, which GetCoverArt returns decoded.
+func (m *MP4Tag) GetArtworks() []Artwork {
+	return m.artworks
+}
+
+// SetArtworks replaces all cover art images, a CoverArt set before included.
+func (m *MP4Tag) SetArtworks(artworks []Artwork) {
+	m.coverArtImage = m.CoverArt
+	m.artworks = artworks
+	m.syncCoverArt()
+}
+
+// AddArtwork appends an image to the cover art.
+func (m *MP4Tag) AddArtwork(artwork Artwork) {
+	m.applyCoverArt()
+	m.artworks = append(m.artworks, artwork)
+	m.syncCoverArt()
+}
+
+// RemoveArtwork removes the i-th image of the cover art.
+func (m *MP4Tag) RemoveArtwork(i int) {
+	m.applyCoverArt()
+	if i < 0 || i >= len(m.artworks) {
+		return
+	}
+	m.artworks = append(m.artworks[:i:i], m.artworks[i+1:]...)
+	m.syncCoverArt()
+}
+
+// MoveArtwork moves the image at index from to index to, shifting the ones in between.
+func (m *MP4Tag) MoveArtwork(from, to int) {
+	m.applyCoverArt()
+	if from < 0 || from >= len(m.artworks) || to < 0 || to >= len(m.artworks) {
+		return
+	}
+	artworks := append(m.artworks[:from:from], m.artworks[from+1:]...)
+	m.artworks = append(artworks[:to:to], append([]Artwork{m.artworks[from]}, artworks[to:]...)...)
+	m.syncCoverArt()
+}
+
+// GetCoverArtData returns the front cover as stored in the file, or nil.
+func (m *MP4Tag) GetCoverArtData() *Artwork {
+	if len(m.artworks) == 0 {
+		return nil
+	}
+	return &m.artworks[0]
+}
+
+// SetCoverArtData sets the front cover to an encoded image. The data type is
+// taken from the image format, so the bytes are written as they are.
+func (m *MP4Tag) SetCoverArtData(data []byte) {
+	if data == nil {
+		m.SetCoverArt(nil)
+		return
+	}
+	m.CoverArt = m.coverArtImage
+	if len(m.artworks) == 0 {
+		m.artworks = []Artwork{NewArtwork(data)}
+	} else {
+		m.artworks = append([]Artwork{NewArtwork(data)}, m.artworks[1:]...)
+	}
+	m.syncCoverArt()
+}
+
+// applyCoverArt puts a changed CoverArt into the artworks, so the artwork
+// setters after SetCoverArt work on the new front cover.
+func (m *MP4Tag) applyCoverArt() {
+	if m.CoverArt == m.coverArtImage {
+		return
+	}
+	// an image that can't be encoded is left to fail on save
+	artworks, err := m.artworksData()
+	if err != nil {
+		return
+	}
+	m.artworks = artworks
+	m.coverArtImage = m.CoverArt
+}
+
+// syncCoverArt decodes the front cover into CoverArt, unless CoverArt was
+// changed and is still to replace the front cover on save.
+func (m *MP4Tag) syncCoverArt() {
+	if m.CoverArt != m.coverArtImage {
+		return
+	}
+	m.CoverArt = nil
+	if len(m.artworks) > 0 {
+		m.CoverArt = decodeArtwork(m.artworks[0].Data)
+	}
+	m.coverArtImage = m.CoverArt
+}
+
+// artworkType detects the covr data type of an encoded image.
+func artworkType(data []byte) uint32 {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return ArtworkTypeJPEG
+	case bytes.HasPrefix(data, []byte{0x89, 'P', 'N', 'G'}):
+		return ArtworkTypePNG
+	case bytes.HasPrefix(data, []byte{'B', 'M'}):
+		return ArtworkTypeBMP
+	}
+	return 0
+}
+
+// decodeArtwork decodes an image, or returns nil for formats without a decoder.
+func decodeArtwork(data []byte) *image.Image {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	return &img
+}
+
+// artworksData returns the covr data to write. Art that was read or set as
+// bytes is returned untouched. A changed CoverArt replaces the front cover
+// and is encoded as PNG.
+func (m *MP4Tag) artworksData() ([]Artwork, error) {
+	if m.CoverArt == m.coverArtImage {
+		return m.artworks, nil
+	}
+	var rest []Artwork
+	if len(m.artworks) > 0 {
+		rest = m.artworks[1:]
+	}
+	if m.CoverArt == nil {
+		return rest, nil
+	}
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, *m.CoverArt); err != nil {
+		return nil, err
+	}
+	return append([]Artwork{{Type: ArtworkTypePNG, Data: buf.Bytes()}}, rest...), nil
+}
@@ -0,0 +1,344 @@
+package mp4meta
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+	"time"
+
+	mp4lib "github.com/abema/go-mp4"
+)
+
+// AudioProperties describes the first audio track of a file.
+type AudioProperties struct {
+	Duration time.Duration
+	// Codec is "aac", "mp3", "alac", "opus", "flac", "ac-3" or "ec-3", or
+	// the sample entry type for other formats
+	Codec string
+	// AACObjectType is the MPEG-4 audio object type of AAC, e.g. 2 for AAC LC
+	// or 5 for HE-AAC
+	AACObjectType int
+	SampleRate    int
+	Channels      int
+	// BitsPerSample is set for lossless codecs
+	BitsPerSample int
+	// Bitrate is the average bitrate in bits per second
+	Bitrate int
+}
+
+// GetAudioProperties returns the properties of the first audio track, or nil
+// if the file has none.
+func (m *MP4Tag) GetAudioProperties() *AudioProperties {
+	return m.audio
+}
+
+// sampleEntry is the first entry of an stsd box.
+type sampleEntry struct {
+	boxType mp4lib.BoxType
+	// fields are the sample entry fields after the 8 byte SampleEntry header
+	fields []byte
+	// children are the boxes after the fields
+	children []byte
+}
+
+// firstSampleEntry returns the first entry of the stsd payload. headerSize
+// returns the size of the fields of an entry.
+func firstSampleEntry(stsd []byte, headerSize func(fields []byte) int) (sampleEntry, bool) {
+	if len(stsd) < 16 || binary.BigEndian.Uint32(stsd[4:]) == 0 {
+		return sampleEntry{}, false
+	}
+	entry := stsd[8:]
+	size := binary.BigEndian.Uint32(entry)
+	if size < 16 || uint64(size) > uint64(len(entry)) {
+		return sampleEntry{}, false
+	}
+	body := entry[16:size]
+	n := headerSize(body)
+	if n > len(body) {
+		return sampleEntry{}, false
+	}
+	return sampleEntry{
+		boxType:  mp4lib.BoxType{entry[4], entry[5], entry[6], entry[7]},
+		fields:   body[:n],
+		children: body[n:],
+	}, true
+}
+
+// childBox returns the payload of the first child box of the given type.
+func childBox(boxes []byte, boxType mp4lib.BoxType) []byte {
+	for len(boxes) >= 8 {
+		size := binary.BigEndian.Uint32(boxes)
+		if size < 8 || uint64(size) > uint64(len(boxes)) {
+			return nil
+		}
+		if (mp4lib.BoxType{boxes[4], boxes[5], boxes[6], boxes[7]}) == boxType {
+			return boxes[8:size]
+		}
+		boxes = boxes[size:]
+	}
+	return nil
+}
+
+// audioSampleEntrySize returns the size of the fields of an audio sample
+// entry, QuickTime versions 1 and 2 have more of them.
+func audioSampleEntrySize(fields []byte) int {
+	if len(fields) < 2 {
+		return 20
+	}
+	switch binary.BigEndian.Uint16(fields) {
+	case 1:
+		return 20 + 16
+	case 2:
+		return 20 + 36
+	}
+	return 20
+}
+
+// readAudioProperties returns the properties of the first audio track of movie.
+func readAudioProperties(movie *movieInfo) *AudioProperties {
+	var track *trackInfo
+	for _, t := range movie.tracks {
+		if t.isAudio() {
+			track = t
+			break
+		}
+	}
+	if track == nil {
+		return nil
+	}
+	props := &AudioProperties{}
+	// the media duration is left zero by some muxers, the movie has it then
+	switch {
+	case track.timescale != 0 && track.duration != 0:
+		props.Duration = mediaTime(track.duration, track.timescale)
+	case movie.timescale != 0:
+		props.Duration = mediaTime(movie.duration, movie.timescale)
+	}
+	var declaredBitrate int
+	if entry, ok := firstSampleEntry(track.stsd, audioSampleEntrySize); ok {
+		props.Codec = strings.TrimSpace(entry.boxType.String())
+		f := entry.fields
+		props.Channels = int(binary.BigEndian.Uint16(f[8:]))
+		// the 16.16 rate can't hold 96 kHz, the codec configuration can
+		props.SampleRate = int(binary.BigEndian.Uint32(f[16:]) >> 16)
+		if binary.BigEndian.Uint16(f) == 2 && len(f) >= 40 {
+			props.SampleRate = int(math.Float64frombits(binary.BigEndian.Uint64(f[24:])))
+			props.Channels = int(binary.BigEndian.Uint32(f[32:]))
+		}
+		declaredBitrate = props.readCodecConfig(entry)
+	}
+	// the size of the samples beats the bitrate the encoder declared
+	if props.Bitrate = declaredBitrate; track.mediaSize > 0 && props.Duration > 0 {
+		props.Bitrate = int(float64(track.mediaSize*8) / props.Duration.Seconds())
+	}
+	return props
+}
+
+// readCodecConfig reads the codec configuration box of the entry. It returns
+// the bitrate the configuration declares, if any.
+func (p *AudioProperties) readCodecConfig(entry sampleEntry) int {
+	switch entry.boxType {
+	case mp4lib.BoxType{'m', 'p', '4', 'a'}:
+		esds := childBox(entry.children, mp4lib.BoxType{'e', 's', 'd', 's'})
+		if esds == nil {
+			// QuickTime puts it into a wave box
+			esds = childBox(childBox(entry.children, mp4lib.BoxType{'w', 'a', 'v', 'e'}), mp4lib.BoxType{'e', 's', 'd', 's'})
+		}
+		if len(esds) < 4 {
+			return 0
+		}
+		return p.readEsds(esds[4:])
+	case mp4lib.BoxType{'a', 'l', 'a', 'c'}:
+		p.Codec = "alac"
+		if c := childBox(entry.children, entry.boxType); len(c) >= 28 {
+			c = c[4:]
+			p.BitsPerSample = int(c[5])
+			p.Channels = int(c[9])
+			p.SampleRate = int(binary.BigEndian.Uint32(c[20:]))
+			return int(binary.BigEndian.Uint32(c[16:]))
+		}
+	case mp4lib.BoxType{'O', 'p', 'u', 's'}:
+		p.Codec = "opus"
+		// Opus is always decoded at 48 kHz
+		p.SampleRate = 48000
+		if c := childBox(entry.children, mp4lib.BoxType{'d', 'O', 'p', 's'}); len(c) >= 2 {
+			p.Channels = int(c[1])
+		}
+	case mp4lib.BoxType{'f', 'L', 'a', 'C'}:
+		p.Codec = "flac"
+		// the STREAMINFO block comes first
+		if c := childBox(entry.children, mp4lib.BoxType{'d', 'f', 'L', 'a'}); len(c) >= 4+4+18 && c[4]&0x7F == 0 {
+			info := c[8:]
+			p.SampleRate = int(info[10])<<12 | int(info[11])<<4 | int(info[12])>>4
+			p.Channels = int(info[12]>>1&0x07) + 1
+			p.BitsPerSample = int(info[12]&0x01)<<4 | int(info[13]>>4) + 1
+		}
+	case mp4lib.BoxType{'a', 'c', '-', '3'}:
+		p.Codec = "ac-3"
+		if c := childBox(entry.children, mp4lib.BoxType{'d', 'a', 'c', '3'}); len(c) >= 3 {
+			b := newBitReader(c)
+			fscod := b.read(2)
+			b.read(5 + 3)
+			p.SampleRate = ac3SampleRate(fscod)
+			p.Channels = ac3Channels(b.read(3), b.read(1))
+			if code := b.read(5); int(code) < len(ac3Bitrates) {
+				return ac3Bitrates[code] * 1000
+			}
+		}
+	case mp4lib.BoxType{'e', 'c', '-', '3'}:
+		p.Codec = "ec-3"
+		if c := childBox(entry.children, mp4lib.BoxType{'d', 'e', 'c', '3'}); len(c) >= 5 {
+			b := newBitReader(c)
+			rate := int(b.read(13)) * 1000
+			b.read(3)
+			fscod := b.read(2)
+			b.read(5 + 1 + 1 + 3)
+			p.SampleRate = ac3SampleRate(fscod)
+			p.Channels = ac3Channels(b.read(3), b.read(1))
+			return rate
+		}
+	}
+	return 0
+}
+
+// readEsds reads the decoder configuration of an ES descriptor.
+func (p *AudioProperties) readEsds(desc []byte) int {
+	tag, body := readDescriptor(desc)
+	if tag != 0x03 || len(body) < 3 {
+		return 0
+	}
+	flags := body[2]
+	body = body[3:]
+	if flags&0x80 != 0 && len(body) >= 2 {
+		body = body[2:]
+	}
+	if flags&0x40 != 0 && len(body) >= 1 {
+		n := int(body[0]) + 1
+		if n > len(body) {
+			return 0
+		}
+		body = body[n:]
+	}
+	if flags&0x20 != 0 && len(body) >= 2 {
+		body = body[2:]
+	}
+	tag, config := readDescriptor(body)
+	if tag != 0x04 || len(config) < 13 {
+		return 0
+	}
+	switch config[0] {
+	case 0x40, 0x66, 0x67, 0x68:
+		p.Codec = "aac"
+	case 0x69, 0x6B:
+		p.Codec = "mp3"
+	}
+	bitrate := int(binary.BigEndian.Uint32(config[9:]))
+	if tag, asc := readDescriptor(config[13:]); tag == 0x05 && p.Codec == "aac" {
+		p.readAudioSpecificConfig(asc)
+	}
+	return bitrate
+}
+
+// readAudioSpecificConfig reads the object type, sample rate and channels of
+// an MPEG-4 AudioSpecificConfig.
+func (p *AudioProperties) readAudioSpecificConfig(asc []byte) {
+	if len(asc) < 2 {
+		return
+	}
+	b := newBitReader(asc)
+	objectType := func() int {
+		ot := int(b.read(5))
+		if ot == 31 {
+			ot = 32 + int(b.read(6))
+		}
+		return ot
+	}
+	sampleRate := func() int {
+		index := b.read(4)
+		if index == 0x0F {
+			return int(b.read(24))
+		}
+		if int(index) < len(aacSampleRates) {
+			return aacSampleRates[index]
+		}
+		return 0
+	}
+	p.AACObjectType = objectType()
+	if rate := sampleRate(); rate != 0 {
+		p.SampleRate = rate
+	}
+	channels := int(b.read(4))
+	if p.AACObjectType == 5 || p.AACObjectType == 29 {
+		// SBR doubles the rate of the core
+		if rate := sampleRate(); rate != 0 {
+			p.SampleRate = rate
+		}
+		if p.AACObjectType == 29 {
+			// parametric stereo
+			channels = 2
+		}
+	}
+	switch {
+	case channels > 0 && channels < 7:
+		p.Channels = channels
+	case channels == 7:
+		p.Channels = 8
+	}
+}
+
+// readDescriptor returns the tag and the body of an MPEG-4 descriptor.
+func readDescriptor(b []byte) (byte, []byte) {
+	if len(b) < 2 {
+		return 0, nil
+	}
+	tag := b[0]
+	n := 0
+	i := 1
+	for ; i < len(b) && i <= 4; i++ {
+		n = n<<7 | int(b[i]&0x7F)
+		if b[i]&0x80 == 0 {
+			i++
+			break
+		}
+	}
+	if i+n > len(b) {
+		return tag, b[i:]
+	}
+	return tag, b[i : i+n]
+}
+
+var aacSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// ac3Bitrates are the bitrates in kbit/s of the AC-3 bit rate codes.
+var ac3Bitrates = []int{32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 448, 512, 576, 640}
+
+func ac3SampleRate(fscod uint64) int {
+	return [...]int{48000, 44100, 32000, 0}[fscod&0x03]
+}
+
+// ac3Channels returns the channel count of an AC-3 audio coding mode.
+func ac3Channels(acmod, lfeon uint64) int {
+	return [...]int{2, 1, 2, 3, 3, 4, 4, 5}[acmod&0x07] + int(lfeon)
+}
+
+// bitReader reads big endian bit fields, reading past the end yields zeros.
+type bitReader struct {
+	b   []byte
+	pos int
+}
+
+func newBitReader(b []byte) *bitReader {
+	return &bitReader{b: b}
+}
+
+func (r *bitReader) read(n int) uint64 {
+	var v uint64
+	for i := 0; i < n; i++ {
+		v <<= 1
+		if r.pos/8 < len(r.b) {
+			v |= uint64(r.b[r.pos/8]>>(7-r.pos%8)) & 1
+		}
+		r.pos++
+	}
+	return v
+}
@@ -0,0 +1,314 @@
+package mp4meta
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	mp4lib "github.com/abema/go-mp4"
+)
+
+var (
+	boxTypeChpl = mp4lib.BoxType{'c', 'h', 'p', 'l'}
+	boxTypeNmhd = mp4lib.BoxType{'n', 'm', 'h', 'd'}
+	boxTypeText = mp4lib.BoxType{'t', 'e', 'x', 't'}
+	boxTypeHref = mp4lib.BoxType{'h', 'r', 'e', 'f'}
+	boxTypeEncd = mp4lib.BoxType{'e', 'n', 'c', 'd'}
+)
+
+// maxTextSample is the largest text sample read, the text itself is limited to 64 KiB.
+const maxTextSample = 1024 * 1024
+
+// textTimescale is the media timescale of the text tracks written on save.
+const textTimescale = 1000
+
+// Chapter is a chapter mark of an audiobook or a movie.
+type Chapter struct {
+	Start time.Duration
+	Title string
+	// URL is an optional link, it's only kept by the QuickTime chapter track.
+	URL string
+}
+
+// GetChapters returns the chapters in order of their start.
+func (m *MP4Tag) GetChapters() []Chapter {
+	return m.chapters
+}
+
+// SetChapters replaces the chapters. On save they are written both as a Nero
+// chpl box and as a QuickTime chapter track, setting nil removes both.
+// The chpl box holds the first 255 chapters only, and the chapter track
+// starts at zero, so it reads the first chapter back as starting there.
+func (m *MP4Tag) SetChapters(chapters []Chapter) {
+	m.chapters = chapters
+	m.chaptersChanged = true
+}
+
+// readChapters returns the chapters of the QuickTime chapter track, or the
+// Nero chapters if the file has no chapter track.
+func readChapters(r io.ReadSeeker, movie *movieInfo) ([]Chapter, error) {
+	for _, track := range movie.tracks {
+		for _, id := range track.chapterIDs {
+			chapterTrack := movie.track(id)
+			if chapterTrack == nil || !chapterTrack.isText() || chapterTrack.timescale == 0 {
+				continue
+			}
+			var chapters []Chapter
+			for _, s := range chapterTrack.samples() {
+				data, err := readSample(r, s, maxTextSample)
+				if err != nil {
+					return nil, err
+				}
+				title, url := parseTextSample(data)
+				chapters = append(chapters, Chapter{
+					Start: mediaTime(s.start, chapterTrack.timescale),
+					Title: title,
+					URL:   url,
+				})
+			}
+			if len(chapters) > 0 {
+				return chapters, nil
+			}
+		}
+	}
+	return movie.chpl, nil
+}
+
+// mediaTime converts a time in timescale units.
+func mediaTime(t uint64, timescale uint32) time.Duration {
+	ts := uint64(timescale)
+	return time.Duration(t/ts)*time.Second + time.Duration(t%ts)*time.Second/time.Duration(ts)
+}
+
+// parseChpl parses the payload of a Nero chpl box. Start times are in 100ns units.
+func parseChpl(payload []byte) []Chapter {
+	if len(payload) < 4 {
+		return nil
+	}
+	version := payload[0]
+	payload = payload[4:]
+	if version == 1 {
+		if len(payload) < 4 {
+			return nil
+		}
+		payload = payload[4:]
+	}
+	if len(payload) < 1 {
+		return nil
+	}
+	count := int(payload[0])
+	payload = payload[1:]
+	var chapters []Chapter
+	for i := 0; i < count; i++ {
+		if len(payload) < 9 {
+			return nil
+		}
+		start := binary.BigEndian.Uint64(payload)
+		n := int(payload[8])
+		payload = payload[9:]
+		if len(payload) < n {
+			return nil
+		}
+		chapters = append(chapters, Chapter{
+			Start: time.Duration(start) * 100,
+			Title: string(payload[:n]),
+		})
+		payload = payload[n:]
+	}
+	return chapters
+}
+
+// parseTextSample returns the text of a QuickTime or 3GPP text sample and the
+// URL of its href modifier, if any.
+func parseTextSample(data []byte) (string, string) {
+	if len(data) < 2 {
+		return "", ""
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	if n > len(data) {
+		n = len(data)
+	}
+	text := decodeText(data[:n])
+	var url string
+	for atoms := data[n:]; len(atoms) >= 8; {
+		size := binary.BigEndian.Uint32(atoms)
+		if size < 8 || uint64(size) > uint64(len(atoms)) {
+			break
+		}
+		body := atoms[8:size]
+		if (mp4lib.BoxType{atoms[4], atoms[5], atoms[6], atoms[7]}) == boxTypeHref && len(body) >= 5 {
+			urlLen := int(body[4])
+			if 5+urlLen <= len(body) {
+				url = string(body[5 : 5+urlLen])
+			}
+		}
+		atoms = atoms[size:]
+	}
+	return text, url
+}
+
+// decodeText decodes UTF-8 text, or UTF-16 text that starts with a byte order mark.
+func decodeText(b []byte) string {
+	if len(b) < 2 || len(b)%2 != 0 {
+		return string(b)
+	}
+	var order binary.ByteOrder
+	switch {
+	case b[0] == 0xFE && b[1] == 0xFF:
+		order = binary.BigEndian
+	case b[0] == 0xFF && b[1] == 0xFE:
+		order = binary.LittleEndian
+	default:
+		return string(b)
+	}
+	u := make([]uint16, 0, len(b)/2-1)
+	for i := 2; i < len(b); i += 2 {
+		u = append(u, order.Uint16(b[i:]))
+	}
+	return string(utf16.Decode(u))
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// setChapters plans how the chapters of movie are replaced: the old
+// chapter tracks are dropped and a new one is referenced by the first audio
+// track, or the first video track.
+func (e *trackEdit) setChapters(movie *movieInfo, chapters []Chapter) {
+	e.replaceChapters = true
+	e.chapters = append([]Chapter(nil), chapters...)
+	sort.SliceStable(e.chapters, func(i, j int) bool {
+		return e.chapters[i].Start < e.chapters[j].Start
+	})
+	for _, track := range movie.tracks {
+		for _, id := range track.chapterIDs {
+			if chapterTrack := movie.track(id); chapterTrack != nil {
+				e.drop[chapterTrack.offset] = true
+			}
+		}
+	}
+	if len(e.chapters) == 0 || movie.timescale == 0 {
+		return
+	}
+	var target *trackInfo
+	for _, handler := range [][4]byte{{'s', 'o', 'u', 'n'}, {'v', 'i', 'd', 'e'}} {
+		for _, track := range movie.tracks {
+			if target == nil && track.handler == handler && !e.drop[track.offset] {
+				target = track
+			}
+		}
+	}
+	if target == nil {
+		return
+	}
+	e.target = target.offset
+	e.targetHasTref = target.hasTref
+
+	track := &textTrack{
+		handler:     boxTypeText,
+		name:        "Chapters",
+		sampleEntry: boxTypeText,
+		// QuickTime text sample description: centered, black on white,
+		// no font name
+		entry: []byte{
+			0, 0, 0, 0, 0, 0, 0, 1, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+			0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+			0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		},
+	}
+	end := mediaTime(movie.duration, movie.timescale).Milliseconds()
+	var start int64
+	for i, chapter := range e.chapters {
+		// the track starts at zero, so the time before the first chapter is part of it
+		if i > 0 {
+			start = chapter.Start.Milliseconds()
+		}
+		next := end
+		if i+1 < len(e.chapters) {
+			next = e.chapters[i+1].Start.Milliseconds()
+		}
+		track.addSample(textSample(chapter.Title, chapter.URL), start, next)
+	}
+	e.chapterTrackID = e.addTrack(track)
+}
+
+// textSample builds a text sample holding text and, if set, a link to url.
+func textSample(text, url string) []byte {
+	sample := textSampleData(text)
+	if url != "" {
+		url = truncateUTF8(url, math.MaxUint8)
+		href := make([]byte, 8+5+len(url)+1)
+		binary.BigEndian.PutUint32(href, uint32(len(href)))
+		copy(href[4:], boxTypeHref[:])
+		binary.BigEndian.PutUint16(href[10:], uint16(utf8.RuneCount(sample[2:])))
+		href[12] = byte(len(url))
+		copy(href[13:], url)
+		sample = append(sample, href...)
+	}
+	// the text is UTF-8
+	encd := []byte{0, 0, 0, 12, 0, 0, 0, 0, 0, 0, 1, 0}
+	copy(encd[4:], boxTypeEncd[:])
+	return append(sample, encd...)
+}
+
+// writeChpl writes the Nero chpl box if the chapters are replaced. Nothing is
+// written without chapters.
+func (e *trackEdit) writeChpl(w mp4Writer) error {
+	if !e.replaceChapters || len(e.chapters) == 0 {
+		return nil
+	}
+	chapters := e.chapters
+	if len(chapters) > math.MaxUint8 {
+		chapters = chapters[:math.MaxUint8]
+	}
+	// version 1 has four reserved bytes before the count
+	payload := []byte{1, 0, 0, 0, 0, 0, 0, 0, byte(len(chapters))}
+	for _, chapter := range chapters {
+		title := truncateUTF8(chapter.Title, math.MaxUint8)
+		start := make([]byte, 8)
+		binary.BigEndian.PutUint64(start, uint64(chapter.Start/100))
+		payload = append(payload, start...)
+		payload = append(payload, byte(len(title)))
+		payload = append(payload, title...)
+	}
+	return writeRawBox(w, boxTypeChpl, payload)
+}
+
+// writeTref writes the tref box of the track at trak with its chapter
+// references replaced. payload is the old tref payload, nil if there was none.
+func (e *trackEdit) writeTref(w mp4Writer, trak uint64, payload []byte) error {
+	var refs []byte
+	for len(payload) >= 8 {
+		size := binary.BigEndian.Uint32(payload)
+		if size < 8 || uint64(size) > uint64(len(payload)) {
+			break
+		}
+		if (mp4lib.BoxType{payload[4], payload[5], payload[6], payload[7]}) != boxTypeChap {
+			refs = append(refs, payload[:size]...)
+		}
+		payload = payload[size:]
+	}
+	if e.chapterTrackID != 0 && trak == e.target {
+		chap := []byte{0, 0, 0, 12, 0, 0, 0, 0, 0, 0, 0, 0}
+		copy(chap[4:], boxTypeChap[:])
+		binary.BigEndian.PutUint32(chap[8:], e.chapterTrackID)
+		refs = append(refs, chap...)
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+	return writeRawBox(w, boxTypeTref, refs)
+}
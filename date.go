@@ -0,0 +1,125 @@
+package mp4meta
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FreeformNameOriginalDate is the freeform item holding the original release
+// date, as written by MusicBrainz Picard.
+const FreeformNameOriginalDate = "originaldate"
+
+// DatePrecision is how much of a ReleaseDate is known.
+type DatePrecision int
+
+const (
+	DatePrecisionYear DatePrecision = iota + 1
+	DatePrecisionMonth
+	DatePrecisionDay
+	DatePrecisionMinute
+	DatePrecisionTime
+)
+
+// ReleaseDate is a date that may be known to the year, month, day, minute or
+// second. Fractions of a second are kept with DatePrecisionTime.
+// The zero value is no date.
+type ReleaseDate struct {
+	Time      time.Time
+	Precision DatePrecision
+}
+
+// releaseDateLayouts are the layouts tried by ParseReleaseDate. Fractional
+// seconds are accepted by all time layouts.
+var releaseDateLayouts = []struct {
+	layout    string
+	precision DatePrecision
+}{
+	{"2006", DatePrecisionYear},
+	{"2006-01", DatePrecisionMonth},
+	{"2006-01-02", DatePrecisionDay},
+	{time.RFC3339, DatePrecisionTime},
+	{"2006-01-02T15:04:05Z0700", DatePrecisionTime},
+	{"2006-01-02T15:04:05", DatePrecisionTime},
+	{"2006-01-02 15:04:05", DatePrecisionTime},
+	{"2006-01-02T15:04Z07:00", DatePrecisionMinute},
+	{"2006-01-02T15:04", DatePrecisionMinute},
+}
+
+// ParseReleaseDate parses the ISO 8601 dates found in ©day items: a year,
+// a year and month, a full date or a timestamp. Timestamps without a zone
+// are taken as UTC.
+func ParseReleaseDate(s string) (ReleaseDate, error) {
+	s = strings.TrimSpace(s)
+	for _, l := range releaseDateLayouts {
+		if t, err := time.Parse(l.layout, s); err == nil {
+			return ReleaseDate{Time: t, Precision: l.precision}, nil
+		}
+	}
+	return ReleaseDate{}, fmt.Errorf("invalid release date %q", s)
+}
+
+// IsZero reports whether d is no date.
+func (d ReleaseDate) IsZero() bool {
+	return d.Precision == 0
+}
+
+// String formats d to its precision, timestamps as RFC 3339 with the
+// fraction of a second, if any.
+func (d ReleaseDate) String() string {
+	switch d.Precision {
+	case DatePrecisionYear:
+		return d.Time.Format("2006")
+	case DatePrecisionMonth:
+		return d.Time.Format("2006-01")
+	case DatePrecisionDay:
+		return d.Time.Format("2006-01-02")
+	case DatePrecisionMinute:
+		return d.Time.Format("2006-01-02T15:04Z07:00")
+	case DatePrecisionTime:
+		return d.Time.Format(time.RFC3339Nano)
+	}
+	return ""
+}
+
+// GetReleaseDate parses the ©day item. It returns the zero ReleaseDate if
+// the item isn't set.
+func (m *MP4Tag) GetReleaseDate() (ReleaseDate, error) {
+	if strings.TrimSpace(m.Year) == "" {
+		return ReleaseDate{}, nil
+	}
+	return ParseReleaseDate(m.Year)
+}
+
+// SetReleaseDate sets the ©day item to d written to its precision, the zero
+// ReleaseDate removes it.
+func (m *MP4Tag) SetReleaseDate(d ReleaseDate) {
+	m.Year = d.String()
+}
+
+// GetOriginalReleaseDate parses the original release date freeform item. It
+// returns the zero ReleaseDate if the item isn't set.
+func (m *MP4Tag) GetOriginalReleaseDate() (ReleaseDate, error) {
+	for _, freeform := range m.freeforms {
+		if freeform.Mean == FreeformMeanITunes && strings.EqualFold(freeform.Name, FreeformNameOriginalDate) {
+			return ParseReleaseDate(freeform.text())
+		}
+	}
+	return ReleaseDate{}, nil
+}
+
+// SetOriginalReleaseDate sets the original release date freeform item, the
+// zero ReleaseDate removes it. Items of other taggers that only differ in the
+// case of their name are replaced.
+func (m *MP4Tag) SetOriginalReleaseDate(d ReleaseDate) {
+	var freeforms []Freeform
+	for _, freeform := range m.freeforms {
+		if freeform.Mean != FreeformMeanITunes || !strings.EqualFold(freeform.Name, FreeformNameOriginalDate) {
+			freeforms = append(freeforms, freeform)
+		}
+	}
+	m.freeforms = freeforms
+	if !d.IsZero() {
+		m.SetFreeform(FreeformMeanITunes, FreeformNameOriginalDate, d.String())
+	}
+}
@@ -0,0 +1,109 @@
+package mp4meta
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ReadFile reads the tags of the mp4 file at path. The file is closed before
+// returning, so the tags are saved with SaveFile rather than Save.
+func ReadFile(path string) (*MP4Tag, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tag, err := ReadMP4(f)
+	if err != nil {
+		return nil, err
+	}
+	tag.reader = nil
+	return tag, nil
+}
+
+// SaveFile saves the tags to the mp4 file at path. The new file is written to
+// a temporary file in the same directory, synced and renamed over the
+// original, so a crash never leaves a truncated file behind. The mode and,
+// where the platform allows it, the ownership of the original are kept. A
+// symlink at path is kept and the file it points to is saved. An advisory
+// lock keeps other processes using SaveFile from saving the same file at the
+// same time. With opts.InPlace the ilst box is overwritten and synced when it
+// fits, which is not atomic.
+func SaveFile(path string, _tags *MP4Tag, opts SaveOptions) error {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockPath(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	if opts.InPlace {
+		ok, err := saveInPlace(src, src, _tags, &opts)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if opts.PreserveModTime {
+				return os.Chtimes(path, time.Now(), fi.ModTime())
+			}
+			return nil
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	// the buffer also keeps saveMP4 from treating tmp as the source file
+	bw := bufio.NewWriterSize(tmp, 1024*1024)
+	full := opts
+	full.InPlace = false
+	if err := SaveMP4WithOptions(src, bw, _tags, full); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	// chown clears the setuid and setgid bits, so the mode is set after it
+	if err := chownLike(tmp, fi); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(fi.Mode().Perm() | fi.Mode()&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if opts.PreserveModTime {
+		if err := os.Chtimes(tmp.Name(), time.Now(), fi.ModTime()); err != nil {
+			return err
+		}
+	}
+	// Windows can't rename over a file that is still open, the lock is kept
+	if err := src.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
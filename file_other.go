@@ -0,0 +1,49 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+
+package mp4meta
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// lockTimeout is how long lockPath waits for another process to save. A lock
+// file older than that was left behind by a process that died.
+const lockTimeout = 30 * time.Second
+
+// lockPath takes an advisory lock on the file at path by creating a lock file
+// next to it.
+func lockPath(path string) (unlock func(), err error) {
+	name := path + ".lock"
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		lf, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			lf.Close()
+			return func() { _ = os.Remove(name) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if fi, err := os.Stat(name); err == nil && time.Since(fi.ModTime()) > lockTimeout {
+			// stale, the next attempt creates it again
+			_ = os.Remove(name)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("mp4meta: %s is locked by another process", path)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// chownLike is a no-op where files have no unix owner.
+func chownLike(f *os.File, fi os.FileInfo) error {
+	return nil
+}
+
+// syncDir is a no-op where directories can't be synced.
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,78 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package mp4meta
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockPath takes an exclusive advisory lock on the file at path, waiting for
+// other holders. The lock is held by a descriptor of its own, so the file may
+// be closed and replaced before unlock is called. Since saving renames a new
+// file over path, the lock is taken again if path was replaced while waiting
+// for it.
+func lockPath(path string) (unlock func(), err error) {
+	for {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := lockFile(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		fi, err := f.Stat()
+		if err == nil {
+			var pfi os.FileInfo
+			if pfi, err = os.Stat(path); err == nil && os.SameFile(fi, pfi) {
+				return func() {
+					unlockFile(f)
+					f.Close()
+				}, nil
+			}
+		}
+		unlockFile(f)
+		f.Close()
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+}
+
+// lockFile takes an exclusive advisory lock on f, waiting for other holders.
+func lockFile(f *os.File) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+func unlockFile(f *os.File) {
+	_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+// chownLike gives f the owner and group of fi. Only privileged processes may
+// give a file away, so a permission error leaves the file with ours.
+func chownLike(f *os.File, fi os.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if err := f.Chown(int(st.Uid), int(st.Gid)); err != nil && !os.IsPermission(err) {
+		return err
+	}
+	return nil
+}
+
+// syncDir makes the rename of a file in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,62 @@
+//go:build windows
+
+package mp4meta
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32       = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx = kernel32.NewProc("LockFileEx")
+)
+
+const lockfileExclusiveLock = 0x2
+
+// lockPath takes an exclusive lock on a lock file next to path, waiting for
+// other holders. Windows can't rename over a file that is open, so the lock
+// isn't taken on the file itself. The system releases it when the process
+// dies, a lock file left behind is simply locked again.
+func lockPath(path string) (unlock func(), err error) {
+	name := path + ".lock"
+	for {
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0600)
+		if err != nil {
+			return nil, err
+		}
+		var ol syscall.Overlapped
+		r, _, errno := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock, 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+		if r == 0 {
+			f.Close()
+			return nil, errno
+		}
+		// the lock file may have been removed by the holder before
+		fi, err := f.Stat()
+		if err == nil {
+			var pfi os.FileInfo
+			if pfi, err = os.Stat(name); err == nil && os.SameFile(fi, pfi) {
+				return func() {
+					f.Close()
+					// fails while others wait for the lock, they remove it
+					_ = os.Remove(name)
+				}, nil
+			}
+		}
+		f.Close()
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+}
+
+// chownLike is a no-op where files have no unix owner.
+func chownLike(f *os.File, fi os.FileInfo) error {
+	return nil
+}
+
+// syncDir is a no-op where directories can't be synced.
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,221 @@
+package mp4meta
+
+import (
+	"encoding/binary"
+
+	mp4lib "github.com/abema/go-mp4"
+)
+
+// FreeformMeanITunes is the mean used by iTunes and most taggers for freeform items.
+const FreeformMeanITunes = "com.apple.iTunes"
+
+var (
+	boxTypeFreeform = mp4lib.BoxType{'-', '-', '-', '-'}
+	boxTypeMean     = mp4lib.BoxType{'m', 'e', 'a', 'n'}
+	boxTypeName     = mp4lib.BoxType{'n', 'a', 'm', 'e'}
+)
+
+// Freeform is an iTunes freeform "----" item. It is identified by the pair
+// (Mean, Name), e.g. ("com.apple.iTunes", "MusicBrainz Album Id"). Each value
+// is a data box, taggers like Picard write one per value of multi-valued
+// items such as "MusicBrainz Artist Id".
+type Freeform struct {
+	Mean   string
+	Name   string
+	Values []FreeformValue
+}
+
+// FreeformValue is a value of a freeform item. Locale holds the country and
+// language of its data box, it is zero by default.
+type FreeformValue struct {
+	DataType uint32
+	Locale   uint32
+	Data     []byte
+}
+
+// text returns the first value as a string.
+func (f Freeform) text() string {
+	if len(f.Values) == 0 {
+		return ""
+	}
+	v := f.Values[0]
+	return decodeDataText(&mp4lib.Data{DataType: v.DataType, DataLang: v.Locale, Data: v.Data})
+}
+
+// GetFreeforms returns all freeform items in file order.
+func (m *MP4Tag) GetFreeforms() []Freeform {
+	return m.freeforms
+}
+
+// GetFreeform returns the first value of the freeform item (mean, name) as a
+// string.
+func (m *MP4Tag) GetFreeform(mean, name string) string {
+	if i := m.freeformIndex(mean, name); i >= 0 {
+		return m.freeforms[i].text()
+	}
+	return ""
+}
+
+// SetFreeform sets the freeform item (mean, name) to a UTF-8 value.
+func (m *MP4Tag) SetFreeform(mean, name, value string) {
+	m.SetFreeformData(Freeform{
+		Mean:   mean,
+		Name:   name,
+		Values: []FreeformValue{{DataType: mp4lib.DataTypeStringUTF8, Data: []byte(value)}},
+	})
+}
+
+// SetFreeformData adds the freeform item or replaces the one with the same
+// mean and name, including items kept as unknown atoms.
+func (m *MP4Tag) SetFreeformData(freeform Freeform) {
+	m.removeFreeformAtoms(freeform.Mean, freeform.Name)
+	if i := m.freeformIndex(freeform.Mean, freeform.Name); i >= 0 {
+		m.freeforms[i] = freeform
+		return
+	}
+	m.freeforms = append(m.freeforms, freeform)
+}
+
+// RemoveFreeform removes the freeform item (mean, name), including items kept
+// as unknown atoms.
+func (m *MP4Tag) RemoveFreeform(mean, name string) {
+	m.removeFreeformAtoms(mean, name)
+	if i := m.freeformIndex(mean, name); i >= 0 {
+		m.freeforms = append(m.freeforms[:i:i], m.freeforms[i+1:]...)
+	}
+}
+
+// removeFreeformAtoms removes the "----" items (mean, name) that are kept as
+// unknown atoms because they couldn't be parsed.
+func (m *MP4Tag) removeFreeformAtoms(mean, name string) {
+	var atoms []Atom
+	for _, atom := range m.unknownAtoms {
+		if atom.Type == boxTypeFreeform {
+			if atomMean, atomName := freeformKey(atom.Data); atomMean == mean && atomName == name {
+				continue
+			}
+		}
+		atoms = append(atoms, atom)
+	}
+	m.unknownAtoms = atoms
+}
+
+func (m *MP4Tag) freeformIndex(mean, name string) int {
+	for i, freeform := range m.freeforms {
+		if freeform.Mean == mean && freeform.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseFreeform parses the payload of a "----" item. Items that don't hold
+// exactly one mean, one name and at least one data box are reported as not
+// ok, so they can be kept as unknown atoms instead.
+func parseFreeform(payload []byte) (Freeform, bool) {
+	var freeform Freeform
+	var means, names int
+	for len(payload) >= 8 {
+		size := binary.BigEndian.Uint32(payload)
+		if size < 8 || uint64(size) > uint64(len(payload)) {
+			return Freeform{}, false
+		}
+		boxType := mp4lib.BoxType{payload[4], payload[5], payload[6], payload[7]}
+		body := payload[8:size]
+		payload = payload[size:]
+		switch boxType {
+		case boxTypeMean, boxTypeName:
+			// version and flags precede the string
+			if len(body) < 4 {
+				return Freeform{}, false
+			}
+			if boxType == boxTypeMean {
+				freeform.Mean = string(body[4:])
+				means++
+			} else {
+				freeform.Name = string(body[4:])
+				names++
+			}
+		case mp4lib.BoxTypeData():
+			if len(body) < 8 {
+				return Freeform{}, false
+			}
+			freeform.Values = append(freeform.Values, FreeformValue{
+				DataType: binary.BigEndian.Uint32(body),
+				Locale:   binary.BigEndian.Uint32(body[4:]),
+				Data:     body[8:],
+			})
+		default:
+			return Freeform{}, false
+		}
+	}
+	if len(payload) != 0 || means != 1 || names != 1 || len(freeform.Values) == 0 {
+		return Freeform{}, false
+	}
+	return freeform, true
+}
+
+// freeformKey returns the mean and name of the payload of a "----" item that
+// couldn't be parsed, the first of each if there are several.
+func freeformKey(payload []byte) (mean, name string) {
+	var hasMean, hasName bool
+	for len(payload) >= 8 {
+		size := binary.BigEndian.Uint32(payload)
+		if size < 8 || uint64(size) > uint64(len(payload)) {
+			break
+		}
+		boxType := mp4lib.BoxType{payload[4], payload[5], payload[6], payload[7]}
+		if body := payload[8:size]; len(body) >= 4 {
+			switch {
+			case boxType == boxTypeMean && !hasMean:
+				mean, hasMean = string(body[4:]), true
+			case boxType == boxTypeName && !hasName:
+				name, hasName = string(body[4:]), true
+			}
+		}
+		payload = payload[size:]
+	}
+	return mean, name
+}
+
+// writeFreeform writes a "----" item with its mean and name and a data box
+// per value.
+func writeFreeform(w mp4Writer, ctx mp4lib.Context, freeform Freeform) error {
+	if _, err := w.StartBox(&mp4lib.BoxInfo{Type: boxTypeFreeform}); err != nil {
+		return err
+	}
+	for _, s := range []struct {
+		boxType mp4lib.BoxType
+		value   string
+	}{{boxTypeMean, freeform.Mean}, {boxTypeName, freeform.Name}} {
+		if _, err := w.StartBox(&mp4lib.BoxInfo{Type: s.boxType}); err != nil {
+			return err
+		}
+		if _, err := w.Write(append(make([]byte, 4), s.value...)); err != nil {
+			return err
+		}
+		if _, err := w.EndBox(); err != nil {
+			return err
+		}
+	}
+	dataCtx := ctx
+	dataCtx.UnderIlstMeta = true
+	for _, value := range freeform.Values {
+		if _, err := w.StartBox(&mp4lib.BoxInfo{Type: mp4lib.BoxTypeData()}); err != nil {
+			return err
+		}
+		boxData := &mp4lib.Data{
+			DataType: value.DataType,
+			DataLang: value.Locale,
+			Data:     value.Data,
+		}
+		if _, err := mp4lib.Marshal(w, boxData, dataCtx); err != nil {
+			return err
+		}
+		if _, err := w.EndBox(); err != nil {
+			return err
+		}
+	}
+	_, err := w.EndBox()
+	return err
+}
@@ -0,0 +1,275 @@
+package mp4meta
+
+import (
+	"encoding/binary"
+
+	mp4lib "github.com/abema/go-mp4"
+)
+
+// Keys written by iPhones and QuickTime.
+const (
+	KeyMake            = "com.apple.quicktime.make"
+	KeyModel           = "com.apple.quicktime.model"
+	KeySoftware        = "com.apple.quicktime.software"
+	KeyCreationDate    = "com.apple.quicktime.creationdate"
+	KeyLocationISO6709 = "com.apple.quicktime.location.ISO6709"
+)
+
+var (
+	boxTypeKeys = mp4lib.BoxType{'k', 'e', 'y', 's'}
+	boxTypeMdta = mp4lib.BoxType{'m', 'd', 't', 'a'}
+)
+
+// KeyedItem is a value of the QuickTime keyed metadata of moov/meta, whose
+// handler is mdta, e.g. the "com.apple.quicktime.make" of an iPhone video.
+// Only the movie's keyed metadata is read and written, the meta boxes of
+// tracks (trak/meta) aren't read and are kept as they are on save.
+type KeyedItem struct {
+	Key      string
+	DataType uint32
+	Data     []byte
+
+	// namespace of the key, mdta if not set
+	namespace mp4lib.BoxType
+	locale    uint32
+}
+
+// GetKeyedItems returns the keyed metadata items in file order.
+func (m *MP4Tag) GetKeyedItems() []KeyedItem {
+	return m.keyedItems
+}
+
+// GetKeyed returns the value of the keyed item as a string.
+func (m *MP4Tag) GetKeyed(key string) string {
+	if i := m.keyedIndex(key); i >= 0 {
+		item := m.keyedItems[i]
+		return decodeDataText(&mp4lib.Data{DataType: item.DataType, Data: item.Data})
+	}
+	return ""
+}
+
+// SetKeyed sets the keyed item to a UTF-8 value.
+func (m *MP4Tag) SetKeyed(key, value string) {
+	m.SetKeyedData(KeyedItem{
+		Key:      key,
+		DataType: mp4lib.DataTypeStringUTF8,
+		Data:     []byte(value),
+	})
+}
+
+// SetKeyedData adds the keyed item or replaces the one with the same key.
+func (m *MP4Tag) SetKeyedData(item KeyedItem) {
+	m.keyedChanged = true
+	if i := m.keyedIndex(item.Key); i >= 0 {
+		if item.namespace == (mp4lib.BoxType{}) {
+			item.namespace = m.keyedItems[i].namespace
+		}
+		m.keyedItems[i] = item
+		return
+	}
+	m.keyedItems = append(m.keyedItems, item)
+}
+
+// RemoveKeyed removes the keyed item.
+func (m *MP4Tag) RemoveKeyed(key string) {
+	if i := m.keyedIndex(key); i >= 0 {
+		m.keyedChanged = true
+		m.keyedItems = append(m.keyedItems[:i:i], m.keyedItems[i+1:]...)
+	}
+}
+
+func (m *MP4Tag) keyedIndex(key string) int {
+	for i, item := range m.keyedItems {
+		if item.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// keyedMeta is a parsed moov/meta box with the mdta handler.
+type keyedMeta struct {
+	// fullBox is set if the box has a version and flags, QuickTime leaves them out
+	fullBox bool
+	// children are the child boxes in order, with their headers
+	children []rawBox
+	items    []KeyedItem
+}
+
+// rawBox is a child box, its payload starts after an 8 byte header.
+type rawBox struct {
+	boxType mp4lib.BoxType
+	data    []byte
+}
+
+// parseKeyedMeta parses the payload of a moov/meta box. It reports false if
+// the box isn't keyed metadata.
+func parseKeyedMeta(payload []byte) (*keyedMeta, bool) {
+	meta := &keyedMeta{}
+	if len(payload) >= 8 && (mp4lib.BoxType{payload[4], payload[5], payload[6], payload[7]}) != mp4lib.BoxTypeHdlr() {
+		meta.fullBox = true
+		payload = payload[4:]
+	}
+	var keys [][]byte
+	var ilst []byte
+	isMdta := false
+	for len(payload) >= 8 {
+		size := binary.BigEndian.Uint32(payload)
+		// 64-bit sizes and boxes up to the end aren't used in meta boxes
+		if size < 8 || uint64(size) > uint64(len(payload)) {
+			return nil, false
+		}
+		child := rawBox{
+			boxType: mp4lib.BoxType{payload[4], payload[5], payload[6], payload[7]},
+			data:    payload[:size],
+		}
+		body := child.data[8:]
+		switch child.boxType {
+		case mp4lib.BoxTypeHdlr():
+			isMdta = len(body) >= 12 && (mp4lib.BoxType{body[8], body[9], body[10], body[11]}) == boxTypeMdta
+		case boxTypeKeys:
+			keys = parseKeys(body)
+		case mp4lib.BoxTypeIlst():
+			ilst = body
+		}
+		meta.children = append(meta.children, child)
+		payload = payload[size:]
+	}
+	if !isMdta {
+		return nil, false
+	}
+	// the item types are 1-based indexes into the keys
+	for len(ilst) >= 8 {
+		size := binary.BigEndian.Uint32(ilst)
+		if size < 8 || uint64(size) > uint64(len(ilst)) {
+			break
+		}
+		index := binary.BigEndian.Uint32(ilst[4:])
+		if index >= 1 && uint64(index) <= uint64(len(keys)) {
+			key := keys[index-1]
+			for _, data := range parseDataBoxes(ilst[8:size]) {
+				meta.items = append(meta.items, KeyedItem{
+					Key:       string(key[4:]),
+					DataType:  data.DataType,
+					Data:      data.Data,
+					namespace: mp4lib.BoxType{key[0], key[1], key[2], key[3]},
+					locale:    data.DataLang,
+				})
+			}
+		}
+		ilst = ilst[size:]
+	}
+	return meta, true
+}
+
+// parseKeys returns the entries of a keys box payload, each a namespace
+// followed by the key.
+func parseKeys(payload []byte) [][]byte {
+	if len(payload) < 8 {
+		return nil
+	}
+	count := binary.BigEndian.Uint32(payload[4:])
+	payload = payload[8:]
+	var keys [][]byte
+	for i := uint32(0); i < count && len(payload) >= 8; i++ {
+		size := binary.BigEndian.Uint32(payload)
+		if size < 8 || uint64(size) > uint64(len(payload)) {
+			break
+		}
+		keys = append(keys, payload[4:size])
+		payload = payload[size:]
+	}
+	return keys
+}
+
+// writeKeyedMeta writes a moov/meta box holding the items. The other
+// children of meta, like hdlr, are kept, a new box gets an mdta handler.
+func writeKeyedMeta(w mp4Writer, meta *keyedMeta, items []KeyedItem) error {
+	if meta == nil {
+		hdlr := make([]byte, 8+25)
+		binary.BigEndian.PutUint32(hdlr, uint32(len(hdlr)))
+		copy(hdlr[4:], "hdlr")
+		copy(hdlr[16:], boxTypeMdta[:])
+		meta = &keyedMeta{children: []rawBox{{boxType: mp4lib.BoxTypeHdlr(), data: hdlr}}}
+	}
+	keys, ilst := renderKeyed(items)
+	if _, err := w.StartBox(&mp4lib.BoxInfo{Type: mp4lib.BoxTypeMeta()}); err != nil {
+		return err
+	}
+	if meta.fullBox {
+		if _, err := w.Write(make([]byte, 4)); err != nil {
+			return err
+		}
+	}
+	written := false
+	for _, child := range meta.children {
+		switch child.boxType {
+		case boxTypeKeys:
+			// ilst follows keys
+			continue
+		case mp4lib.BoxTypeIlst():
+			if err := writeKeyedTables(w, keys, ilst); err != nil {
+				return err
+			}
+			written = true
+			continue
+		}
+		if _, err := w.Write(child.data); err != nil {
+			return err
+		}
+	}
+	if !written {
+		if err := writeKeyedTables(w, keys, ilst); err != nil {
+			return err
+		}
+	}
+	_, err := w.EndBox()
+	return err
+}
+
+func writeKeyedTables(w mp4Writer, keys, ilst []byte) error {
+	if err := writeRawBox(w, boxTypeKeys, keys); err != nil {
+		return err
+	}
+	return writeRawBox(w, mp4lib.BoxTypeIlst(), ilst)
+}
+
+// renderKeyed returns the payloads of the keys and ilst boxes of the items.
+// Items with the same key share one keys entry and one ilst item.
+func renderKeyed(items []KeyedItem) ([]byte, []byte) {
+	var order []KeyedItem
+	values := map[string][]KeyedItem{}
+	for _, item := range items {
+		if _, ok := values[item.Key]; !ok {
+			order = append(order, item)
+		}
+		values[item.Key] = append(values[item.Key], item)
+	}
+	keys := make([]byte, 8)
+	binary.BigEndian.PutUint32(keys[4:], uint32(len(order)))
+	var ilst []byte
+	for i, first := range order {
+		namespace := first.namespace
+		if namespace == (mp4lib.BoxType{}) {
+			namespace = boxTypeMdta
+		}
+		entry := make([]byte, 8, 8+len(first.Key))
+		binary.BigEndian.PutUint32(entry, uint32(8+len(first.Key)))
+		copy(entry[4:], namespace[:])
+		keys = append(keys, append(entry, first.Key...)...)
+
+		item := make([]byte, 8)
+		binary.BigEndian.PutUint32(item[4:], uint32(i+1))
+		for _, value := range values[first.Key] {
+			data := make([]byte, 16, 16+len(value.Data))
+			binary.BigEndian.PutUint32(data, uint32(16+len(value.Data)))
+			copy(data[4:], "data")
+			binary.BigEndian.PutUint32(data[8:], value.DataType)
+			binary.BigEndian.PutUint32(data[12:], value.locale)
+			item = append(item, append(data, value.Data...)...)
+		}
+		binary.BigEndian.PutUint32(item, uint32(len(item)))
+		ilst = append(ilst, item...)
+	}
+	return keys, ilst
+}
@@ -0,0 +1,141 @@
+package mp4meta
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	mp4lib "github.com/abema/go-mp4"
+)
+
+var (
+	boxTypeXyz  = mp4lib.BoxType{'\251', 'x', 'y', 'z'}
+	boxTypeLoci = mp4lib.BoxType{'l', 'o', 'c', 'i'}
+)
+
+// locationKeyPrefix starts the keys of the location and its accuracy.
+const locationKeyPrefix = "com.apple.quicktime.location."
+
+// iso6709 matches a point: latitude, longitude, an optional altitude and
+// coordinate reference system.
+var iso6709 = regexp.MustCompile(`^([+-][0-9]+(?:\.[0-9]*)?)([+-][0-9]+(?:\.[0-9]*)?)([+-][0-9]+(?:\.[0-9]*)?)?(?:CRS[^/]*)?/?$`)
+
+// Location is a capture location in degrees, with the altitude in meters.
+type Location struct {
+	Latitude    float64
+	Longitude   float64
+	Altitude    float64
+	HasAltitude bool
+}
+
+// ParseISO6709 parses an ISO 6709 point like "+37.7858-122.4064+012.345/".
+// Latitude and longitude may be given in degrees, degrees and minutes or
+// degrees, minutes and seconds.
+func ParseISO6709(s string) (Location, error) {
+	m := iso6709.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return Location{}, fmt.Errorf("invalid ISO 6709 location %q", s)
+	}
+	var l Location
+	var err error
+	if l.Latitude, err = parseISO6709Angle(m[1], 2, 90); err != nil {
+		return Location{}, err
+	}
+	if l.Longitude, err = parseISO6709Angle(m[2], 3, 180); err != nil {
+		return Location{}, err
+	}
+	if m[3] != "" {
+		if l.Altitude, err = strconv.ParseFloat(m[3], 64); err != nil {
+			return Location{}, err
+		}
+		l.HasAltitude = true
+	}
+	return l, nil
+}
+
+// parseISO6709Angle parses a signed angle whose degrees have up to digits
+// digits, followed by two digits of minutes and two of seconds if present.
+func parseISO6709Angle(s string, digits int, limit float64) (float64, error) {
+	sign := 1.0
+	if s[0] == '-' {
+		sign = -1
+	}
+	s = s[1:]
+	n := strings.IndexByte(s, '.')
+	if n < 0 {
+		n = len(s)
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case n <= digits:
+	case n == digits+2:
+		v = math.Floor(v/100) + math.Mod(v, 100)/60
+	case n == digits+4:
+		v = math.Floor(v/10000) + math.Floor(math.Mod(v, 10000)/100)/60 + math.Mod(v, 100)/3600
+	default:
+		return 0, fmt.Errorf("invalid ISO 6709 angle %q", s)
+	}
+	if v > limit {
+		return 0, fmt.Errorf("ISO 6709 angle %q out of range", s)
+	}
+	return sign * v, nil
+}
+
+// ISO6709 formats l as used by QuickTime, like "+37.7858-122.4064+012.345/".
+func (l Location) ISO6709() string {
+	s := fmt.Sprintf("%+08.4f%+09.4f", l.Latitude, l.Longitude)
+	if l.HasAltitude {
+		s += fmt.Sprintf("%+08.3f", l.Altitude)
+	}
+	return s + "/"
+}
+
+// GetLocation returns the location of the QuickTime location key, or of the
+// ©xyz atom of moov/udta. It reports false if there is no valid location.
+func (m *MP4Tag) GetLocation() (Location, bool) {
+	for _, s := range []string{m.GetKeyed(KeyLocationISO6709), m.xyz} {
+		if s == "" {
+			continue
+		}
+		if l, err := ParseISO6709(s); err == nil {
+			return l, true
+		}
+	}
+	return Location{}, false
+}
+
+// SetLocation sets the ©xyz atom, and the location key if the file has
+// keyed metadata. 3GPP loci atoms are removed, they would tell the old one.
+func (m *MP4Tag) SetLocation(l Location) {
+	m.xyz = l.ISO6709()
+	m.locationChanged = true
+	if len(m.keyedItems) > 0 {
+		m.SetKeyed(KeyLocationISO6709, m.xyz)
+	}
+}
+
+// RemoveLocation strips the location before publishing: the ©xyz atom, the
+// 3GPP loci atoms of the movie and its tracks and all location keys,
+// including their accuracy and name.
+func (m *MP4Tag) RemoveLocation() {
+	m.xyz = ""
+	m.locationChanged = true
+	for _, item := range append([]KeyedItem(nil), m.keyedItems...) {
+		if strings.HasPrefix(item.Key, locationKeyPrefix) {
+			m.RemoveKeyed(item.Key)
+		}
+	}
+}
+
+// writeXyz writes the ©xyz atom if the location changed and is set.
+func writeXyz(w mp4Writer, _tags *MP4Tag) error {
+	if !_tags.locationChanged || _tags.xyz == "" {
+		return nil
+	}
+	return writeRawBox(w, boxTypeXyz, quickTimeText(_tags.xyz, PackLanguage("eng")))
+}
@@ -0,0 +1,192 @@
+package mp4meta
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	mp4lib "github.com/abema/go-mp4"
+)
+
+var boxTypeTx3g = mp4lib.BoxType{'t', 'x', '3', 'g'}
+
+// lyricsTrackName is the handler name of the track synced lyrics are stored in.
+const lyricsTrackName = "Lyrics"
+
+var (
+	lrcTimestamp = regexp.MustCompile(`^\[(\d+):(\d{1,2})(?:[.:](\d{1,3}))?\]`)
+	lrcOffset    = regexp.MustCompile(`^\[offset:\s*([+-]?\d+)\s*\]`)
+)
+
+// LyricLine is a line of time-synced lyrics.
+type LyricLine struct {
+	Start time.Duration
+	Text  string
+}
+
+// GetSyncedLyrics returns the time-synced lyrics in order of their start.
+func (m *MP4Tag) GetSyncedLyrics() []LyricLine {
+	return m.syncedLyrics
+}
+
+// SetSyncedLyrics replaces the time-synced lyrics. On save they are written
+// to a 3GPP timed text (tx3g) track named "Lyrics", setting nil removes it.
+// Each line lasts until the next one starts, lines that start at the same
+// millisecond are joined into one, separated by a newline.
+func (m *MP4Tag) SetSyncedLyrics(lines []LyricLine) {
+	m.syncedLyrics = lines
+	m.syncedLyricsChanged = true
+}
+
+// ParseLRC parses lyrics in the LRC format. A line with several timestamps
+// is repeated at each of them, and an [offset:ms] tag is applied. Lines
+// without a timestamp, like the [ar:artist] tags, are skipped.
+func ParseLRC(r io.Reader) ([]LyricLine, error) {
+	var lines []LyricLine
+	var offset time.Duration
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if m := lrcOffset.FindStringSubmatch(line); m != nil {
+			ms, err := strconv.Atoi(m[1])
+			if err != nil {
+				return nil, err
+			}
+			offset = time.Duration(ms) * time.Millisecond
+			continue
+		}
+		var starts []time.Duration
+		for {
+			m := lrcTimestamp.FindStringSubmatch(line)
+			if m == nil {
+				break
+			}
+			minutes, _ := strconv.Atoi(m[1])
+			seconds, _ := strconv.Atoi(m[2])
+			// fractions of a second are hundredths mostly, but can have 1 to 3 digits
+			fraction, _ := strconv.Atoi((m[3] + "00")[:3])
+			starts = append(starts, time.Duration(minutes)*time.Minute+time.Duration(seconds)*time.Second+time.Duration(fraction)*time.Millisecond)
+			line = line[len(m[0]):]
+		}
+		for _, start := range starts {
+			lines = append(lines, LyricLine{Start: start, Text: strings.TrimSpace(line)})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	// a positive offset shows the lyrics sooner
+	for i := range lines {
+		lines[i].Start -= offset
+		if lines[i].Start < 0 {
+			lines[i].Start = 0
+		}
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].Start < lines[j].Start
+	})
+	return lines, nil
+}
+
+// FormatLRC formats lyrics in the LRC format, with timestamps in hundredths of a second.
+func FormatLRC(lines []LyricLine) string {
+	var b strings.Builder
+	for _, line := range lines {
+		centis := line.Start.Milliseconds() / 10
+		fmt.Fprintf(&b, "[%02d:%02d.%02d]%s\n", centis/6000, centis/100%60, centis%100, line.Text)
+	}
+	return b.String()
+}
+
+// lyricsTrack returns the track synced lyrics are stored in, or nil.
+func (m *movieInfo) lyricsTrack() *trackInfo {
+	for _, track := range m.tracks {
+		if track.isText() && track.sampleEntry == boxTypeTx3g && track.name == lyricsTrackName && track.timescale != 0 {
+			return track
+		}
+	}
+	return nil
+}
+
+// readSyncedLyrics returns the lines of the lyrics track.
+func readSyncedLyrics(r io.ReadSeeker, movie *movieInfo) ([]LyricLine, error) {
+	track := movie.lyricsTrack()
+	if track == nil {
+		return nil, nil
+	}
+	var lines []LyricLine
+	for i, s := range track.samples() {
+		data, err := readSample(r, s, maxTextSample)
+		if err != nil {
+			return nil, err
+		}
+		text, _ := parseTextSample(data)
+		// an empty sample fills the time before the first line
+		if i == 0 && s.start == 0 && text == "" {
+			continue
+		}
+		lines = append(lines, LyricLine{Start: mediaTime(s.start, track.timescale), Text: text})
+	}
+	return lines, nil
+}
+
+// mergeLyricLines returns the lines in order of their start, with the lines
+// that start at the same millisecond joined. A sample can't be empty, so they
+// can't be samples of their own without moving the lines after them.
+func mergeLyricLines(lines []LyricLine) []LyricLine {
+	sorted := append([]LyricLine(nil), lines...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Start < sorted[j].Start
+	})
+	var merged []LyricLine
+	for _, line := range sorted {
+		if n := len(merged); n > 0 && merged[n-1].Start.Milliseconds() == line.Start.Milliseconds() {
+			merged[n-1].Text += "\n" + line.Text
+			continue
+		}
+		merged = append(merged, line)
+	}
+	return merged
+}
+
+// setSyncedLyrics plans how the lyrics track of movie is replaced.
+func (e *trackEdit) setSyncedLyrics(movie *movieInfo, lines []LyricLine) {
+	if old := movie.lyricsTrack(); old != nil {
+		e.drop[old.offset] = true
+	}
+	if len(lines) == 0 || movie.timescale == 0 {
+		return
+	}
+	lines = mergeLyricLines(lines)
+	track := &textTrack{
+		handler:     [4]byte{'s', 'b', 't', 'l'},
+		name:        lyricsTrackName,
+		sampleEntry: boxTypeTx3g,
+		// 3GPP text sample entry: centered at the bottom, white on
+		// transparent, with a font table holding "Serif" as font 1
+		entry: []byte{
+			0, 0, 0, 0, 1, 0xFF, 0, 0, 0, 0,
+			0, 0, 0, 0, 0, 0, 0, 0,
+			0, 0, 0, 0, 0, 1, 0, 18, 0xFF, 0xFF, 0xFF, 0xFF,
+			0, 0, 0, 18, 'f', 't', 'a', 'b', 0, 1, 0, 1, 5, 'S', 'e', 'r', 'i', 'f',
+		},
+	}
+	end := mediaTime(movie.duration, movie.timescale).Milliseconds()
+	if first := lines[0].Start.Milliseconds(); first > 0 {
+		track.addSample(textSampleData(""), 0, first)
+	}
+	for i, line := range lines {
+		next := end
+		if i+1 < len(lines) {
+			next = lines[i+1].Start.Milliseconds()
+		}
+		track.addSample(textSampleData(line.Text), line.Start.Milliseconds(), next)
+	}
+	e.addTrack(track)
+}
@@ -0,0 +1,37 @@
+package mp4meta
+
+// MediaKind is the iTunes media type of a file, stored in the stik item.
+// The zero value leaves stik out of the file.
+type MediaKind int
+
+const (
+	MediaKindMusic      MediaKind = 1
+	MediaKindAudiobook  MediaKind = 2
+	MediaKindMusicVideo MediaKind = 6
+	MediaKindMovie      MediaKind = 9
+	MediaKindTVShow     MediaKind = 10
+	MediaKindBooklet    MediaKind = 11
+	MediaKindRingtone   MediaKind = 14
+	MediaKindPodcast    MediaKind = 21
+	MediaKindITunesU    MediaKind = 23
+)
+
+// ContentRating is the iTunes advisory rating, stored in the rtng item.
+// The zero value means no rating and leaves rtng out of the file.
+type ContentRating int
+
+const (
+	ContentRatingExplicit ContentRating = 1
+	ContentRatingClean    ContentRating = 2
+	// ContentRatingExplicitOld is the explicit rating written by old iTunes versions.
+	ContentRatingExplicitOld ContentRating = 4
+)
+
+// HDVideo is the definition of a video, stored in the hdvd item. The zero
+// value is standard definition and leaves hdvd out of the file.
+type HDVideo int
+
+const (
+	HDVideo720p  HDVideo = 1
+	HDVideo1080p HDVideo = 2
+)
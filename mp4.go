@@ -1,10 +1,12 @@
 package mp4meta
 
 import (
+	"errors"
 	"fmt"
 	"image"
 	"io"
 	"strconv"
+	"time"
 
 	mp4lib "github.com/abema/go-mp4"
 )
@@ -25,6 +27,80 @@ var atomsMap = map[mp4lib.BoxType]string{
 	{'d', 'i', 's', 'k'}:    "DiscNumber",  //2uint16 (disc) (totaldiscs:"DiscNumber":
 	{'\251', 't', 'o', 'o'}: "Encoder",
 	{'t', 'm', 'p', 'o'}:    "BPM", //bigEndianUin:"BPM":
+	{'\251', 'l', 'y', 'r'}: "Lyrics",
+	{'s', 'o', 'n', 'm'}:    "SortTitle",
+	{'s', 'o', 'a', 'r'}:    "SortArtist",
+	{'s', 'o', 'a', 'a'}:    "SortAlbumArtist",
+	{'s', 'o', 'a', 'l'}:    "SortAlbum",
+	{'s', 'o', 'c', 'o'}:    "SortComposer",
+	{'s', 'o', 's', 'n'}:    "SortShow",
+	{'\251', 'g', 'r', 'p'}: "Grouping",
+	{'\251', 'w', 'r', 'k'}: "Work",
+	{'\251', 'm', 'v', 'n'}: "MovementName",
+	{'\251', 'm', 'v', 'i'}: "MovementNumber",   //uint16
+	{'\251', 'm', 'v', 'c'}: "MovementCount",    //uint16
+	{'s', 'h', 'w', 'm'}:    "ShowWorkMovement", //uint8
+	{'c', 'p', 'i', 'l'}:    "Compilation",      //uint8
+	{'p', 'g', 'a', 'p'}:    "GaplessPlayback",  //uint8
+	{'s', 't', 'i', 'k'}:    "MediaKind",        //uint8
+	{'r', 't', 'n', 'g'}:    "ContentRating",    //uint8
+	{'p', 'c', 's', 't'}:    "Podcast",          //uint8
+	{'p', 'u', 'r', 'l'}:    "PodcastURL",
+	{'e', 'g', 'i', 'd'}:    "EpisodeGUID",
+	{'c', 'a', 't', 'g'}:    "Category",
+	{'k', 'e', 'y', 'w'}:    "Keywords",
+	{'d', 'e', 's', 'c'}:    "Description",
+	{'l', 'd', 'e', 's'}:    "LongDescription",
+	{'t', 'v', 's', 'h'}:    "TVShow",
+	{'t', 'v', 'e', 'n'}:    "TVEpisodeID",
+	{'t', 'v', 's', 'n'}:    "TVSeason",  //uint32
+	{'t', 'v', 'e', 's'}:    "TVEpisode", //uint32
+	{'t', 'v', 'n', 'n'}:    "TVNetwork",
+	{'h', 'd', 'v', 'd'}:    "HDVideo",      //uint8
+	{'c', 'n', 'I', 'D'}:    "ContentID",    //uint32
+	{'a', 't', 'I', 'D'}:    "ArtistID",     //uint32
+	{'p', 'l', 'I', 'D'}:    "PlaylistID",   //uint64
+	{'g', 'e', 'I', 'D'}:    "GenreID",      //uint32
+	{'s', 'f', 'I', 'D'}:    "StorefrontID", //uint32
+	{'a', 'k', 'I', 'D'}:    "AccountKind",  //uint8
+	{'c', 'm', 'I', 'D'}:    "ComposerID",   //uint32
+	{'p', 'u', 'r', 'd'}:    "PurchaseDate",
+	{'a', 'p', 'I', 'D'}:    "AppleID",
+	{'o', 'w', 'n', 'r'}:    "Owner",
+}
+
+// atomSizes holds the size in bytes of the integer items. Flags are
+// written as 8-bit integers.
+var atomSizes = map[string]int{
+	"MovementNumber": 2,
+	"MovementCount":  2,
+	"MediaKind":      1,
+	"ContentRating":  1,
+	"TVSeason":       4,
+	"TVEpisode":      4,
+	"HDVideo":        1,
+	"ContentID":      4,
+	"ArtistID":       4,
+	"PlaylistID":     8,
+	"GenreID":        4,
+	"StorefrontID":   4,
+	"AccountKind":    1,
+	"ComposerID":     4,
+}
+
+// atomDataTypes holds the data type of the text items that iTunes doesn't
+// write as UTF-8.
+var atomDataTypes = map[string]uint32{
+	"PodcastURL":  mp4lib.DataTypeBinary,
+	"EpisodeGUID": mp4lib.DataTypeBinary,
+}
+
+// Atom is an ilst item that has no MP4Tag field, such as an iTunes "xid "
+// item. Data is the item payload without its box header. Atoms are
+// captured by ReadMP4 and written back byte-for-byte by SaveMP4.
+type Atom struct {
+	Type mp4lib.BoxType
+	Data []byte
 }
 
 type MP4Tag struct {
@@ -38,16 +114,83 @@ type MP4Tag struct {
 	CoverArt    *image.Image
 	Encoder     string
 	Genre       string
+	Lyrics      string
 	Title       string
 	TrackNumber int
 	TrackTotal  int
 	DiscNumber  int
 	DiscTotal   int
 	Year        string
-	
-	reader      io.ReadSeeker
+
+	SortTitle       string
+	SortArtist      string
+	SortAlbumArtist string
+	SortAlbum       string
+	SortComposer    string
+	SortShow        string
+
+	Grouping         string
+	Work             string
+	MovementName     string
+	MovementNumber   int
+	MovementCount    int
+	ShowWorkMovement bool
+
+	Compilation     bool
+	GaplessPlayback bool
+	MediaKind       MediaKind
+	ContentRating   ContentRating
+
+	Podcast         bool
+	PodcastURL      string
+	EpisodeGUID     string
+	Category        string
+	Keywords        string
+	Description     string
+	LongDescription string
+
+	TVShow      string
+	TVEpisodeID string
+	TVSeason    int
+	TVEpisode   int
+	TVNetwork   string
+	HDVideo     HDVideo
+
+	ContentID    int
+	ArtistID     int
+	PlaylistID   int64
+	GenreID      int
+	StorefrontID int
+	AccountKind  int
+	ComposerID   int
+	PurchaseDate string
+	AppleID      string
+	Owner        string
+
+	artworks        []Artwork
+	coverArtImage   *image.Image
+	values          map[string][]TextValue
+	freeforms       []Freeform
+	unknownAtoms    []Atom
+	keyedItems      []KeyedItem
+	keyedChanged    bool
+	xyz             string
+	locationChanged bool
+	// quickTimeTexts holds the fields read with a moov/udta text atom and
+	// their value after reading, the atoms of changed fields are rewritten
+	quickTimeTexts      map[string]string
+	chapters            []Chapter
+	chaptersChanged     bool
+	syncedLyrics        []LyricLine
+	syncedLyricsChanged bool
+	audio               *AudioProperties
+	video               []VideoProperties
+	reader              io.ReadSeeker
 }
 
+// ClearAllTags clears the fields written to the ilst box. The keyed metadata,
+// the location, the chapters and the synced lyrics are kept, they are removed
+// with RemoveKeyed, RemoveLocation, SetChapters and SetSyncedLyrics.
 func (m *MP4Tag) ClearAllTags() {
 	m.Album = ""
 	m.AlbumArtist = ""
@@ -57,14 +200,80 @@ func (m *MP4Tag) ClearAllTags() {
 	m.Composer = ""
 	m.Copyright = ""
 	m.CoverArt = nil
+	m.artworks = nil
+	m.coverArtImage = nil
 	m.Encoder = ""
 	m.Genre = ""
+	m.Lyrics = ""
 	m.Title = ""
 	m.TrackNumber = 0
 	m.TrackTotal = 0
 	m.DiscNumber = 0
 	m.DiscTotal = 0
 	m.Year = ""
+	m.SortTitle = ""
+	m.SortArtist = ""
+	m.SortAlbumArtist = ""
+	m.SortAlbum = ""
+	m.SortComposer = ""
+	m.SortShow = ""
+	m.Grouping = ""
+	m.Work = ""
+	m.MovementName = ""
+	m.MovementNumber = 0
+	m.MovementCount = 0
+	m.ShowWorkMovement = false
+	m.Compilation = false
+	m.GaplessPlayback = false
+	m.MediaKind = 0
+	m.ContentRating = 0
+	m.Podcast = false
+	m.PodcastURL = ""
+	m.EpisodeGUID = ""
+	m.Category = ""
+	m.Keywords = ""
+	m.Description = ""
+	m.LongDescription = ""
+	m.TVShow = ""
+	m.TVEpisodeID = ""
+	m.TVSeason = 0
+	m.TVEpisode = 0
+	m.TVNetwork = ""
+	m.HDVideo = 0
+	m.ContentID = 0
+	m.ArtistID = 0
+	m.PlaylistID = 0
+	m.GenreID = 0
+	m.StorefrontID = 0
+	m.AccountKind = 0
+	m.ComposerID = 0
+	m.PurchaseDate = ""
+	m.AppleID = ""
+	m.Owner = ""
+	m.values = nil
+	m.freeforms = nil
+	m.unknownAtoms = nil
+}
+
+// GetUnknownAtoms returns the ilst items that are kept as is on save.
+func (m *MP4Tag) GetUnknownAtoms() []Atom {
+	return m.unknownAtoms
+}
+
+// RemoveUnknownAtom removes every unknown ilst item of the given type.
+func (m *MP4Tag) RemoveUnknownAtom(boxType mp4lib.BoxType) {
+	var atoms []Atom
+	for _, atom := range m.unknownAtoms {
+		if atom.Type != boxType {
+			atoms = append(atoms, atom)
+		}
+	}
+	m.unknownAtoms = atoms
+}
+
+// ClearUnknownAtoms removes all unknown ilst items.
+func (m *MP4Tag) ClearUnknownAtoms() {
+	m.unknownAtoms = nil
 }
 
 func (m *MP4Tag) GetAlbum() string {
@@ -107,6 +316,10 @@ func (m *MP4Tag) GetGenre() string {
 	return m.Genre
 }
 
+func (m *MP4Tag) GetLyrics() string {
+	return m.Lyrics
+}
+
 func (m *MP4Tag) GetTitle() string {
 	return m.Title
 }
@@ -130,11 +343,172 @@ func (m *MP4Tag) GetDiscTotal() int {
 func (m *MP4Tag) GetYear() int {
 	year, err := strconv.Atoi(m.Year)
 	if err != nil {
-		return 0
+		// a full date, like "2019-05-17T07:00:00Z"
+		date, err := m.GetReleaseDate()
+		if err != nil || date.IsZero() {
+			return 0
+		}
+		return date.Time.Year()
 	}
 	return year
 }
 
+func (m *MP4Tag) GetSortTitle() string {
+	return m.SortTitle
+}
+
+func (m *MP4Tag) GetSortArtist() string {
+	return m.SortArtist
+}
+
+func (m *MP4Tag) GetSortAlbumArtist() string {
+	return m.SortAlbumArtist
+}
+
+func (m *MP4Tag) GetSortAlbum() string {
+	return m.SortAlbum
+}
+
+func (m *MP4Tag) GetSortComposer() string {
+	return m.SortComposer
+}
+
+func (m *MP4Tag) GetSortShow() string {
+	return m.SortShow
+}
+
+func (m *MP4Tag) GetGrouping() string {
+	return m.Grouping
+}
+
+func (m *MP4Tag) GetWork() string {
+	return m.Work
+}
+
+func (m *MP4Tag) GetMovementName() string {
+	return m.MovementName
+}
+
+func (m *MP4Tag) GetMovementNumber() int {
+	return m.MovementNumber
+}
+
+func (m *MP4Tag) GetMovementCount() int {
+	return m.MovementCount
+}
+
+func (m *MP4Tag) GetShowWorkMovement() bool {
+	return m.ShowWorkMovement
+}
+
+func (m *MP4Tag) GetCompilation() bool {
+	return m.Compilation
+}
+
+func (m *MP4Tag) GetGaplessPlayback() bool {
+	return m.GaplessPlayback
+}
+
+func (m *MP4Tag) GetMediaKind() MediaKind {
+	return m.MediaKind
+}
+
+func (m *MP4Tag) GetContentRating() ContentRating {
+	return m.ContentRating
+}
+
+func (m *MP4Tag) GetPodcast() bool {
+	return m.Podcast
+}
+
+func (m *MP4Tag) GetPodcastURL() string {
+	return m.PodcastURL
+}
+
+func (m *MP4Tag) GetEpisodeGUID() string {
+	return m.EpisodeGUID
+}
+
+func (m *MP4Tag) GetCategory() string {
+	return m.Category
+}
+
+func (m *MP4Tag) GetKeywords() string {
+	return m.Keywords
+}
+
+func (m *MP4Tag) GetDescription() string {
+	return m.Description
+}
+
+func (m *MP4Tag) GetLongDescription() string {
+	return m.LongDescription
+}
+
+func (m *MP4Tag) GetTVShow() string {
+	return m.TVShow
+}
+
+func (m *MP4Tag) GetTVEpisodeID() string {
+	return m.TVEpisodeID
+}
+
+func (m *MP4Tag) GetTVSeason() int {
+	return m.TVSeason
+}
+
+func (m *MP4Tag) GetTVEpisode() int {
+	return m.TVEpisode
+}
+
+func (m *MP4Tag) GetTVNetwork() string {
+	return m.TVNetwork
+}
+
+func (m *MP4Tag) GetHDVideo() HDVideo {
+	return m.HDVideo
+}
+
+func (m *MP4Tag) GetContentID() int {
+	return m.ContentID
+}
+
+func (m *MP4Tag) GetArtistID() int {
+	return m.ArtistID
+}
+
+func (m *MP4Tag) GetPlaylistID() int64 {
+	return m.PlaylistID
+}
+
+func (m *MP4Tag) GetGenreID() int {
+	return m.GenreID
+}
+
+func (m *MP4Tag) GetStorefrontID() int {
+	return m.StorefrontID
+}
+
+func (m *MP4Tag) GetAccountKind() int {
+	return m.AccountKind
+}
+
+func (m *MP4Tag) GetComposerID() int {
+	return m.ComposerID
+}
+
+func (m *MP4Tag) GetPurchaseDate() string {
+	return m.PurchaseDate
+}
+
+func (m *MP4Tag) GetAppleID() string {
+	return m.AppleID
+}
+
+func (m *MP4Tag) GetOwner() string {
+	return m.Owner
+}
+
 func (m *MP4Tag) SetAlbum(album string) {
 	m.Album = album
 }
@@ -156,6 +530,9 @@ func (m *MP4Tag) SetComposer(composer string) {
 func (m *MP4Tag) SetCopyright(copyright string) {
 	m.Copyright = copyright
 }
+
+// SetCoverArt sets the front cover to an image, which is saved as PNG.
+// Setting nil removes the front cover.
 func (m *MP4Tag) SetCoverArt(coverArt *image.Image) {
 	m.CoverArt = coverArt
 }
@@ -165,6 +542,9 @@ func (m *MP4Tag) SetEncoder(encoder string) {
 func (m *MP4Tag) SetGenre(genre string) {
 	m.Genre = genre
 }
+func (m *MP4Tag) SetLyrics(lyrics string) {
+	m.Lyrics = lyrics
+}
 func (m *MP4Tag) SetTitle(title string) {
 	m.Title = title
 }
@@ -180,12 +560,148 @@ func (m *MP4Tag) SetDiscNumber(discNumber int) {
 func (m *MP4Tag) SetDiscTotal(discTotal int) {
 	m.DiscTotal = discTotal
 }
+
+// SetYear sets the year of the release date, keeping its month, day and time.
 func (m *MP4Tag) SetYear(year int) {
-	m.Year = fmt.Sprint(year)
+	date, err := m.GetReleaseDate()
+	if err != nil || date.Precision <= DatePrecisionYear {
+		m.Year = fmt.Sprint(year)
+		return
+	}
+	t := date.Time
+	date.Time = time.Date(year, t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	m.SetReleaseDate(date)
+}
+func (m *MP4Tag) SetSortTitle(sortTitle string) {
+	m.SortTitle = sortTitle
+}
+func (m *MP4Tag) SetSortArtist(sortArtist string) {
+	m.SortArtist = sortArtist
+}
+func (m *MP4Tag) SetSortAlbumArtist(sortAlbumArtist string) {
+	m.SortAlbumArtist = sortAlbumArtist
+}
+func (m *MP4Tag) SetSortAlbum(sortAlbum string) {
+	m.SortAlbum = sortAlbum
+}
+func (m *MP4Tag) SetSortComposer(sortComposer string) {
+	m.SortComposer = sortComposer
+}
+func (m *MP4Tag) SetSortShow(sortShow string) {
+	m.SortShow = sortShow
+}
+func (m *MP4Tag) SetGrouping(grouping string) {
+	m.Grouping = grouping
+}
+func (m *MP4Tag) SetWork(work string) {
+	m.Work = work
+}
+func (m *MP4Tag) SetMovementName(movementName string) {
+	m.MovementName = movementName
+}
+func (m *MP4Tag) SetMovementNumber(movementNumber int) {
+	m.MovementNumber = movementNumber
+}
+func (m *MP4Tag) SetMovementCount(movementCount int) {
+	m.MovementCount = movementCount
+}
+func (m *MP4Tag) SetShowWorkMovement(showWorkMovement bool) {
+	m.ShowWorkMovement = showWorkMovement
+}
+func (m *MP4Tag) SetCompilation(compilation bool) {
+	m.Compilation = compilation
+}
+func (m *MP4Tag) SetGaplessPlayback(gaplessPlayback bool) {
+	m.GaplessPlayback = gaplessPlayback
+}
+func (m *MP4Tag) SetMediaKind(mediaKind MediaKind) {
+	m.MediaKind = mediaKind
+}
+func (m *MP4Tag) SetContentRating(contentRating ContentRating) {
+	m.ContentRating = contentRating
+}
+func (m *MP4Tag) SetPodcast(podcast bool) {
+	m.Podcast = podcast
+}
+func (m *MP4Tag) SetPodcastURL(podcastURL string) {
+	m.PodcastURL = podcastURL
+}
+func (m *MP4Tag) SetEpisodeGUID(episodeGUID string) {
+	m.EpisodeGUID = episodeGUID
+}
+func (m *MP4Tag) SetCategory(category string) {
+	m.Category = category
+}
+func (m *MP4Tag) SetKeywords(keywords string) {
+	m.Keywords = keywords
+}
+func (m *MP4Tag) SetDescription(description string) {
+	m.Description = description
+}
+func (m *MP4Tag) SetLongDescription(longDescription string) {
+	m.LongDescription = longDescription
+}
+func (m *MP4Tag) SetTVShow(tvShow string) {
+	m.TVShow = tvShow
+}
+func (m *MP4Tag) SetTVEpisodeID(tvEpisodeID string) {
+	m.TVEpisodeID = tvEpisodeID
+}
+func (m *MP4Tag) SetTVSeason(tvSeason int) {
+	m.TVSeason = tvSeason
+}
+func (m *MP4Tag) SetTVEpisode(tvEpisode int) {
+	m.TVEpisode = tvEpisode
+}
+func (m *MP4Tag) SetTVNetwork(tvNetwork string) {
+	m.TVNetwork = tvNetwork
+}
+func (m *MP4Tag) SetHDVideo(hdVideo HDVideo) {
+	m.HDVideo = hdVideo
+}
+func (m *MP4Tag) SetContentID(contentID int) {
+	m.ContentID = contentID
+}
+func (m *MP4Tag) SetArtistID(artistID int) {
+	m.ArtistID = artistID
+}
+func (m *MP4Tag) SetPlaylistID(playlistID int64) {
+	m.PlaylistID = playlistID
+}
+func (m *MP4Tag) SetGenreID(genreID int) {
+	m.GenreID = genreID
+}
+func (m *MP4Tag) SetStorefrontID(storefrontID int) {
+	m.StorefrontID = storefrontID
+}
+func (m *MP4Tag) SetAccountKind(accountKind int) {
+	m.AccountKind = accountKind
+}
+func (m *MP4Tag) SetComposerID(composerID int) {
+	m.ComposerID = composerID
+}
+func (m *MP4Tag) SetPurchaseDate(purchaseDate string) {
+	m.PurchaseDate = purchaseDate
+}
+func (m *MP4Tag) SetAppleID(appleID string) {
+	m.AppleID = appleID
+}
+func (m *MP4Tag) SetOwner(owner string) {
+	m.Owner = owner
 }
 
+// Save writes the file the tags were read from to w with the tags. Tags read
+// with ReadFile have no reader to copy the file from, they are saved with
+// SaveFile.
 func (m *MP4Tag) Save(w io.Writer) error {
-	return SaveMP4(m.reader, w, m)
+	return m.SaveWithOptions(w, SaveOptions{})
+}
+
+func (m *MP4Tag) SaveWithOptions(w io.Writer, opts SaveOptions) error {
+	if m.reader == nil {
+		return errors.New("mp4meta: the tags have no reader, save them with SaveFile")
+	}
+	return SaveMP4WithOptions(m.reader, w, m, opts)
 }
 
 // https://github.com/FFmpeg/FFmpeg/blob/4e5523c98597a417eb43555933b1075d18ec5f8b/libavformat/id3v1.c#L278
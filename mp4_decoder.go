@@ -3,7 +3,6 @@ package mp4meta
 import (
 	"bytes"
 	"encoding/binary"
-	"image"
 	"io"
 	"reflect"
 
@@ -14,88 +13,185 @@ import (
 func ReadMP4(reader io.ReadSeeker) (*MP4Tag, error) {
 	tag := new(MP4Tag)
 	tag.reader = reader
-	tptr := reflect.ValueOf(tag).Elem()
 	r := bufseekio.NewReadSeeker(reader, 1024*1024, 4)
-	var ptyp mp4lib.BoxType
-	var field string
+	quickTimeTexts := map[string]string{}
+	// the movie and its tracks are read in the same walk, a track that can't
+	// be read is left out rather than failing the tags
+	mr := &movieReader{movie: new(movieInfo), lenient: true}
 	_, err := mp4lib.ReadBoxStructure(r, func(h *mp4lib.ReadHandle) (val interface{}, err error) {
-		switch h.BoxInfo.Type {
-		case mp4lib.BoxTypeMoov(), mp4lib.BoxTypeUdta(), mp4lib.BoxTypeMeta(), mp4lib.BoxTypeIlst():
-			return h.Expand()
-		case containsAtom(h.BoxInfo.Type):
-			ptyp = h.BoxInfo.Type
-			field = atomsMap[ptyp]
-			return h.Expand()
-		case mp4lib.BoxTypeData():
-			ib, n, err := h.ReadPayload()
-			valueSize := n - 8
-			if err != nil || valueSize < 1 {
+		if isIlstItemPath(h.Path) {
+			buf := new(bytes.Buffer)
+			if _, err := h.ReadData(buf); err != nil {
 				return nil, err
 			}
-			data := ib.(*mp4lib.Data)
-			switch ptyp {
-			case mp4lib.BoxType{'t', 'r', 'k', 'n'}, mp4lib.BoxType{'d', 'i', 's', 'k'}:
-				var num uint16
-				if err := binary.Read(bytes.NewReader(data.Data[2:4]), binary.BigEndian, &num); err != nil {
-					return nil, err
-				}
-				tptr.FieldByName(field).SetInt(int64(num))
-				typ := reflect.TypeOf(*tag)
-				fNum := 0
-			strL:
-				for i := 0; i < typ.NumField(); i++ {
-					if typ.Field(i).Name == field {
-						fNum = i + 1
-						break strL
+			// the data boxes are parsed here, go-mp4 only knows some of the item types
+			if containsAtom(h.BoxInfo.Type) == h.BoxInfo.Type {
+				for _, data := range parseDataBoxes(buf.Bytes()) {
+					if err := tag.readAtomData(h.BoxInfo.Type, data); err != nil {
+						return nil, err
 					}
 				}
-				if err = binary.Read(bytes.NewReader(data.Data[4:6]), binary.BigEndian, &num); err != nil {
-					return nil, err
-				}
-				tptr.Field(fNum).SetInt(int64(num))
-				return nil, nil
-			case mp4lib.BoxType{'t', 'm', 'p', 'o'}:
-				// Win7 Explorer for BPM<256 write int8u:
-				// | | | | BeatsPerMinute = 120
-				// | | | | - Tag 'tmpo', Type='data', Flags=0x15 (signed int), Lang=0x0000 (1 bytes, int8u):
-				// | | | | 29a00e84: 78
-				// mp3tag write always int16u:                                           [x]
-				// | | | | BeatsPerMinute = 120
-				// | | | | - Tag 'tmpo', Type='data', Flags=0x15 (signed int), Lang=0x0000 (2 bytes, int16u):
-				// | | | |    334f8: 00 78                                           [.x]
-				tag.BPM = getInt(data.Data[:valueSize])
-				return nil, nil
-			case mp4lib.BoxType{'g', 'n', 'r', 'e'}:
-				// | | | | Genre = !
-				// | | | | - Tag 'gnre', Type='data', Flags=0x0 (undef), Lang=0x0000 (2 bytes, undef):
-				// | | | | 29a00e9d: 00 21                                           [.!]
-				if tag.Genre == "" { // give priority to (c)gen
-					tag.Genre = Id3v1GenreStr[getInt(data.Data[:valueSize])-1]
-				}
-				return nil, nil
-			case mp4lib.BoxType{'c', 'o', 'v', 'r'}:
-				img, _, err := image.Decode(bytes.NewReader(data.Data))
-				if err != nil {
-					return nil, err
-				}
-				tag.CoverArt = &img
 				return nil, nil
-			case mp4lib.BoxType{'\251', 'a', 'l', 'b'}, mp4lib.BoxType{'a', 'A', 'R', 'T'}, mp4lib.BoxType{'\251', 'A', 'R', 'T'}, mp4lib.BoxType{'\251', 'c', 'm', 't'}, mp4lib.BoxType{'\251', 'w', 'r', 't'}, mp4lib.BoxType{'c', 'p', 'r', 't'}, mp4lib.BoxType{'\251', 'g', 'e', 'n'}, mp4lib.BoxType{'\251', 'n', 'a', 'm'}, mp4lib.BoxType{'\251', 'd', 'a', 'y'}, mp4lib.BoxType{'\251', 't', 'o', 'o'}:
-				if reflect.ValueOf(string(data.Data)).IsZero() {
+			}
+			// keep items without a field as is, so saving doesn't drop them
+			if h.BoxInfo.Type == boxTypeFreeform {
+				if freeform, ok := parseFreeform(buf.Bytes()); ok {
+					tag.freeforms = append(tag.freeforms, freeform)
 					return nil, nil
-				} else {
-					tptr.FieldByName(field).SetString(string(data.Data))
 				}
-
-				return nil, nil
-			default:
+			}
+			tag.unknownAtoms = append(tag.unknownAtoms, Atom{Type: h.BoxInfo.Type, Data: buf.Bytes()})
+			return nil, nil
+		}
+		if len(h.Path) == 2 && h.BoxInfo.Type == mp4lib.BoxTypeMeta() {
+			buf := new(bytes.Buffer)
+			if _, err := h.ReadData(buf); err != nil {
+				return nil, err
+			}
+			if meta, ok := parseKeyedMeta(buf.Bytes()); ok {
+				tag.keyedItems = meta.items
+			}
+			return nil, nil
+		}
+		if len(h.Path) == 3 && h.Path[1] == mp4lib.BoxTypeUdta() && h.BoxInfo.Type == boxTypeXyz {
+			buf := new(bytes.Buffer)
+			if _, err := h.ReadData(buf); err != nil {
+				return nil, err
+			}
+			tag.xyz = parseQuickTimeText(buf.Bytes())
+			return nil, nil
+		}
+		if len(h.Path) == 3 && h.Path[1] == mp4lib.BoxTypeUdta() && quickTimeTextField(h.BoxInfo.Type) != "" {
+			buf := new(bytes.Buffer)
+			if _, err := h.ReadData(buf); err != nil {
+				return nil, err
+			}
+			quickTimeTexts[quickTimeTextField(h.BoxInfo.Type)] = parseQuickTimeText(buf.Bytes())
+			return nil, nil
+		}
+		switch h.BoxInfo.Type {
+		case mp4lib.BoxTypeMeta(), mp4lib.BoxTypeIlst():
+			if !hasPathPrefix(ilstPath, h.Path) {
 				return nil, nil
 			}
+			return h.Expand()
 		}
-		return nil, nil
+		return mr.readBox(h)
 	})
 	if err != nil {
 		return nil, err
 	}
+	// the QuickTime text atoms only fill fields the ilst items left empty
+	tptr := reflect.ValueOf(tag).Elem()
+	for field, text := range quickTimeTexts {
+		if f := tptr.FieldByName(field); f.String() == "" {
+			f.SetString(text)
+		}
+		quickTimeTexts[field] = tptr.FieldByName(field).String()
+	}
+	tag.quickTimeTexts = quickTimeTexts
+	if tag.chapters, err = readChapters(r, mr.movie); err != nil {
+		return nil, err
+	}
+	if tag.syncedLyrics, err = readSyncedLyrics(r, mr.movie); err != nil {
+		return nil, err
+	}
+	tag.audio = readAudioProperties(mr.movie)
+	tag.video = readVideoProperties(mr.movie)
 	return tag, nil
 }
+
+// parseDataBoxes returns the data boxes in the payload of an ilst item.
+func parseDataBoxes(payload []byte) []*mp4lib.Data {
+	var datas []*mp4lib.Data
+	for len(payload) >= 8 {
+		size := binary.BigEndian.Uint32(payload)
+		if size < 8 || uint64(size) > uint64(len(payload)) {
+			break
+		}
+		body := payload[8:size]
+		if (mp4lib.BoxType{payload[4], payload[5], payload[6], payload[7]}) == mp4lib.BoxTypeData() && len(body) >= 8 {
+			datas = append(datas, &mp4lib.Data{
+				DataType: binary.BigEndian.Uint32(body),
+				DataLang: binary.BigEndian.Uint32(body[4:]),
+				Data:     body[8:],
+			})
+		}
+		payload = payload[size:]
+	}
+	return datas
+}
+
+// readAtomData sets the field of the ilst item ptyp from one of its data boxes.
+func (tag *MP4Tag) readAtomData(ptyp mp4lib.BoxType, data *mp4lib.Data) error {
+	tptr := reflect.ValueOf(tag).Elem()
+	field := atomsMap[ptyp]
+	valueSize := len(data.Data)
+	if valueSize < 1 {
+		return nil
+	}
+	switch ptyp {
+	case mp4lib.BoxType{'t', 'r', 'k', 'n'}, mp4lib.BoxType{'d', 'i', 's', 'k'}:
+		if valueSize < 6 {
+			return nil
+		}
+		var num uint16
+		if err := binary.Read(bytes.NewReader(data.Data[2:4]), binary.BigEndian, &num); err != nil {
+			return err
+		}
+		tptr.FieldByName(field).SetInt(int64(num))
+		typ := reflect.TypeOf(*tag)
+		fNum := 0
+	strL:
+		for i := 0; i < typ.NumField(); i++ {
+			if typ.Field(i).Name == field {
+				fNum = i + 1
+				break strL
+			}
+		}
+		if err := binary.Read(bytes.NewReader(data.Data[4:6]), binary.BigEndian, &num); err != nil {
+			return err
+		}
+		tptr.Field(fNum).SetInt(int64(num))
+		return nil
+	case mp4lib.BoxType{'t', 'm', 'p', 'o'}:
+		// Win7 Explorer for BPM<256 write int8u:
+		// | | | | BeatsPerMinute = 120
+		// | | | | - Tag 'tmpo', Type='data', Flags=0x15 (signed int), Lang=0x0000 (1 bytes, int8u):
+		// | | | | 29a00e84: 78
+		// mp3tag write always int16u:                                           [x]
+		// | | | | BeatsPerMinute = 120
+		// | | | | - Tag 'tmpo', Type='data', Flags=0x15 (signed int), Lang=0x0000 (2 bytes, int16u):
+		// | | | |    334f8: 00 78                                           [.x]
+		tag.BPM = getInt(data.Data[:valueSize])
+		return nil
+	case mp4lib.BoxType{'g', 'n', 'r', 'e'}:
+		// | | | | Genre = !
+		// | | | | - Tag 'gnre', Type='data', Flags=0x0 (undef), Lang=0x0000 (2 bytes, undef):
+		// | | | | 29a00e9d: 00 21                                           [.!]
+		if tag.Genre == "" { // give priority to (c)gen
+			tag.Genre = Id3v1GenreStr[getInt(data.Data[:valueSize])-1]
+		}
+		return nil
+	case mp4lib.BoxType{'c', 'o', 'v', 'r'}:
+		// keep the bytes, so unchanged art is written back as is
+		tag.artworks = append(tag.artworks, Artwork{Type: data.DataType, Data: data.Data})
+		if len(tag.artworks) == 1 {
+			tag.CoverArt = decodeArtwork(data.Data)
+			tag.coverArtImage = tag.CoverArt
+		}
+		return nil
+	default:
+		f := tptr.FieldByName(field)
+		switch f.Kind() {
+		case reflect.String:
+			// every data box is a value of its own, kept with its type and locale
+			f.SetString(tag.addTextValue(field, data))
+		case reflect.Bool:
+			f.SetBool(decodeDataInt(data) != 0)
+		case reflect.Int, reflect.Int64:
+			f.SetInt(int64(decodeDataInt(data)))
+		}
+		return nil
+	}
+}
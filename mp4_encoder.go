@@ -3,8 +3,10 @@ package mp4meta
 import (
 	"bytes"
 	"encoding/binary"
-	"image/png"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -30,24 +32,25 @@ type mp4WriteSeeker interface {
 }
 
 // Make new atoms and write to.
-func createAndWrite(w mp4Writer, ctx mp4lib.Context, _tags *MP4Tag) error {
-	var boxData *mp4lib.Data
+func createAndWrite(w mp4Writer, ctx mp4lib.Context, _tags *MP4Tag, opts *SaveOptions) error {
+	var boxDatas []*mp4lib.Data
 	dataCtx := ctx
 	dataCtx.UnderIlstMeta = true
 	for boxType, tagName := range atomsMap {
 		switch tagName {
+		case "Gnre":
+			// genre is always written as (c)gen, the two can't coexist
+			continue
 		case "BPM":
 			if _tags.BPM == 0 {
 				continue
 			}
 			buf := make([]byte, 2)
-			temp := make([]byte, 2)
-			binary.BigEndian.PutUint16(temp, uint16(_tags.BPM))
-			buf = append(temp, buf...)
-			boxData = &mp4lib.Data{
+			binary.BigEndian.PutUint16(buf, uint16(_tags.BPM))
+			boxDatas = []*mp4lib.Data{{
 				DataType: mp4lib.DataTypeSignedIntBigEndian,
 				Data:     buf,
-			}
+			}}
 
 		case "TrackNumber", "DiscNumber":
 			total := strings.ReplaceAll(tagName, "Number", "Total")
@@ -63,47 +66,91 @@ func createAndWrite(w mp4Writer, ctx mp4lib.Context, _tags *MP4Tag) error {
 			binary.BigEndian.PutUint16(temp, uint16(totalVal))
 			buf = append(buf, temp...)
 			buf = append(buf, make([]byte, 2)...)
-			boxData = &mp4lib.Data{
+			boxDatas = []*mp4lib.Data{{
 				DataType: mp4lib.DataTypeBinary,
 				Data:     buf,
-			}
+			}}
 
 		case "CoverArt":
-			if _tags.CoverArt == nil {
-				continue
-			}
-			buf := new(bytes.Buffer)
-			if err := png.Encode(buf, *_tags.CoverArt); err != nil {
+			arts, err := _tags.artworksData()
+			if err != nil {
 				return err
 			}
-			boxData = &mp4lib.Data{
-				DataType: 13,
-				Data:     buf.Bytes(),
+			if len(arts) == 0 {
+				continue
+			}
+			// every image is a data box of its own under covr
+			boxDatas = nil
+			for _, art := range arts {
+				boxDatas = append(boxDatas, &mp4lib.Data{
+					DataType: art.Type,
+					Data:     art.Data,
+				})
 			}
 
 		default:
-			if reflect.ValueOf(*_tags).FieldByName(tagName).IsZero() {
+			val := reflect.ValueOf(*_tags).FieldByName(tagName)
+			if val.IsZero() {
 				continue
 			}
-			val := reflect.ValueOf(*_tags).FieldByName(tagName).String()
-			boxData = &mp4lib.Data{
-				DataType: mp4lib.DataTypeStringUTF8,
-				Data:     []byte(val),
+			switch val.Kind() {
+			case reflect.Bool:
+				boxDatas = []*mp4lib.Data{intData(1, 1)}
+			case reflect.Int, reflect.Int64:
+				boxDatas = []*mp4lib.Data{intData(uint64(val.Int()), atomSizes[tagName])}
+			default:
+				// values are written back with their data type and locale
+				values := _tags.textValues(tagName, val.String())
+				if multiValueFields[tagName] && opts.JoinMultiValues != "" {
+					joined := values[0]
+					for _, v := range values[1:] {
+						joined.Text += opts.JoinMultiValues + v.Text
+					}
+					values = []TextValue{joined}
+				}
+				boxDatas = nil
+				for _, v := range values {
+					if opts.TextAsUTF8 {
+						v.Country, v.Language = 0, 0
+						if v.DataType == mp4lib.DataTypeStringUTF16 || v.DataType == dataTypeStringUTF16Sort {
+							v.DataType = mp4lib.DataTypeStringUTF8
+						}
+					}
+					boxDatas = append(boxDatas, textData(v))
+				}
 			}
 		}
 
 		if _, err := w.StartBox(&mp4lib.BoxInfo{Type: boxType}); err != nil {
 			return err
 		}
-		if _, err := w.StartBox(&mp4lib.BoxInfo{Type: mp4lib.BoxTypeData()}); err != nil {
+		for _, boxData := range boxDatas {
+			if _, err := w.StartBox(&mp4lib.BoxInfo{Type: mp4lib.BoxTypeData()}); err != nil {
+				return err
+			}
+
+			if _, err := mp4lib.Marshal(w, boxData, dataCtx); err != nil {
+				return err
+			}
+
+			if _, err := w.EndBox(); err != nil {
+				return err
+			}
+		}
+		if _, err := w.EndBox(); err != nil {
+			return err
+		}
+	}
+	for _, freeform := range _tags.freeforms {
+		if err := writeFreeform(w, ctx, freeform); err != nil {
 			return err
 		}
-
-		if _, err := mp4lib.Marshal(w, boxData, dataCtx); err != nil {
+	}
+	for _, atom := range _tags.unknownAtoms {
+		if _, err := w.StartBox(&mp4lib.BoxInfo{Type: atom.Type}); err != nil {
 			return err
 		}
-
-		if _, err := w.EndBox(); err != nil {
+		if _, err := w.Write(atom.Data); err != nil {
 			return err
 		}
 		if _, err := w.EndBox(); err != nil {
@@ -114,6 +161,16 @@ func createAndWrite(w mp4Writer, ctx mp4lib.Context, _tags *MP4Tag) error {
 
 }
 
+// intData returns a data box holding n as a big endian integer of size bytes.
+func intData(n uint64, size int) *mp4lib.Data {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, n)
+	return &mp4lib.Data{
+		DataType: mp4lib.DataTypeSignedIntBigEndian,
+		Data:     buf[8-size:],
+	}
+}
+
 func containsAtom(boxType mp4lib.BoxType) mp4lib.BoxType {
 	if _, ok := atomsMap[boxType]; ok {
 		return boxType
@@ -121,13 +178,102 @@ func containsAtom(boxType mp4lib.BoxType) mp4lib.BoxType {
 	return mp4lib.BoxType{}
 }
 
-func saveMP4(r io.ReadSeeker, wo io.Writer, w mp4Writer, ws mp4WriteSeeker, _tags *MP4Tag) error {
-	var mdatOffsetDiff int64
-	var stcoOffsets []int64
+// chunkOffsetTable is a stco or co64 box of the output file.
+type chunkOffsetTable struct {
+	srcOffset uint64
+	dstOffset int64
+}
+
+// mdatMove is the payload range of an mdat box in the source and the output.
+type mdatMove struct {
+	srcOffset uint64
+	dstOffset uint64
+	size      uint64
+}
+
+// topBox is a top level box of the output. A rebuilt box is taken from the
+// moov buffer at [start, start+size), any other box is copied from the source.
+type topBox struct {
+	info    mp4lib.BoxInfo
+	rebuilt bool
+	start   uint64
+	size    uint64
+}
+
+// writeMoov writes the moov box read from r to w with the tags replaced.
+// Tracks are added and dropped by tracks unless it is nil. stco boxes whose
+// source offset is in promote are written as co64. It returns the chunk
+// offset tables written to w and the top level boxes of the output in order.
+func writeMoov(r io.ReadSeeker, w mp4Writer, _tags *MP4Tag, opts *SaveOptions, tracks *trackEdit, promote map[uint64]bool) ([]chunkOffsetTable, []topBox, error) {
+	var boxes []topBox
+	var tables []chunkOffsetTable
 	var ilstExists bool
+	var afterIlst bool
+	var udtaWritten bool
+	var keyedWritten bool
+	var trak uint64
 	rs := bufseekio.NewReadSeeker(r, 1024*1024, 4)
 
 	_, err := mp4lib.ReadBoxStructure(rs, func(h *mp4lib.ReadHandle) (interface{}, error) {
+		// top level boxes other than moov, like mdat, are streamed from the source later
+		if len(h.Path) == 1 && h.BoxInfo.Type != mp4lib.BoxTypeMoov() {
+			if tracks != nil && h.BoxInfo.Type == mp4lib.BoxTypeMdat() && tracks.dropsMdat(&h.BoxInfo) {
+				return nil, nil
+			}
+			boxes = append(boxes, topBox{info: h.BoxInfo})
+			return nil, nil
+		}
+		if tracks != nil && len(h.Path) <= 3 {
+			switch {
+			case len(h.Path) == 2 && h.BoxInfo.Type == mp4lib.BoxTypeTrak():
+				if tracks.drop[h.BoxInfo.Offset] {
+					return nil, nil
+				}
+				trak = h.BoxInfo.Offset
+			case len(h.Path) == 2 && h.BoxInfo.Type == mp4lib.BoxTypeMvhd() && len(tracks.tracks) > 0:
+				return nil, tracks.writeMvhd(w, h)
+			case len(h.Path) == 3 && h.Path[1] == mp4lib.BoxTypeUdta() && h.BoxInfo.Type == boxTypeChpl && tracks.replaceChapters:
+				return nil, nil
+			case len(h.Path) == 3 && h.Path[1] == mp4lib.BoxTypeTrak() && h.BoxInfo.Type == boxTypeTref && tracks.replaceChapters:
+				buf := new(bytes.Buffer)
+				if _, err := h.ReadData(buf); err != nil {
+					return nil, err
+				}
+				return nil, tracks.writeTref(w, trak, buf.Bytes())
+			}
+		}
+		if _tags.keyedChanged && len(h.Path) == 2 && h.BoxInfo.Type == mp4lib.BoxTypeMeta() {
+			buf := new(bytes.Buffer)
+			if _, err := h.ReadData(buf); err != nil {
+				return nil, err
+			}
+			if meta, ok := parseKeyedMeta(buf.Bytes()); ok {
+				keyedWritten = true
+				return nil, writeKeyedMeta(w, meta, _tags.keyedItems)
+			}
+			return nil, w.CopyBox(r, &h.BoxInfo)
+		}
+		if len(h.Path) == 3 && h.Path[1] == mp4lib.BoxTypeUdta() {
+			// written again from the tags at the end of udta
+			if field := quickTimeTextField(h.BoxInfo.Type); field != "" && (opts.QuickTimeText || _tags.quickTimeTextChanged(field)) {
+				return nil, nil
+			}
+			if _tags.locationChanged && h.BoxInfo.Type == boxTypeXyz {
+				return nil, nil
+			}
+		}
+		// the 3GPP location of the movie or a track goes with a changed location
+		if _tags.locationChanged && h.BoxInfo.Type == boxTypeLoci && len(h.Path) >= 3 && h.Path[len(h.Path)-2] == mp4lib.BoxTypeUdta() {
+			return nil, nil
+		}
+		if isIlstSiblingPath(h.Path) {
+			// free boxes right after ilst are replaced by the requested padding
+			isFree := h.BoxInfo.Type == mp4lib.BoxTypeFree() || h.BoxInfo.Type == mp4lib.BoxTypeSkip()
+			if afterIlst && isFree && opts.Padding > 0 {
+				return nil, nil
+			}
+			afterIlst = isIlstPath(h.Path)
+		}
 		switch h.BoxInfo.Type {
 		// 1. moov, trak, mdia, minf, stbl, udta
 		case mp4lib.BoxTypeMoov(),
@@ -138,6 +284,13 @@ func saveMP4(r io.ReadSeeker, wo io.Writer, w mp4Writer, ws mp4WriteSeeker, _tag
 			mp4lib.BoxTypeUdta(),
 			mp4lib.BoxTypeMeta(),
 			mp4lib.BoxTypeIlst():
+			// only the udta ilst is rebuilt, other item lists are kept as is
+			if h.BoxInfo.Type == mp4lib.BoxTypeIlst() && !isIlstPath(h.Path) {
+				if err := w.CopyBox(r, &h.BoxInfo); err != nil {
+					return nil, err
+				}
+				return nil, nil
+			}
 			_, err := w.StartBox(&h.BoxInfo)
 			if err != nil {
 				return nil, err
@@ -149,148 +302,572 @@ func saveMP4(r io.ReadSeeker, wo io.Writer, w mp4Writer, ws mp4WriteSeeker, _tag
 			}
 			// 1-a. [only moov box] add udta box if not exists
 			if h.BoxInfo.Type == mp4lib.BoxTypeMoov() && !ilstExists {
-				path := []mp4lib.BoxType{mp4lib.BoxTypeUdta(), mp4lib.BoxTypeMeta()}
-				for _, boxType := range path {
-					if _, err := w.StartBox(&mp4lib.BoxInfo{Type: boxType}); err != nil {
-						return nil, err
-					}
+				if _, err := w.StartBox(&mp4lib.BoxInfo{Type: mp4lib.BoxTypeUdta()}); err != nil {
+					return nil, err
 				}
-				ctx := h.BoxInfo.Context
-				ctx.UnderUdta = true
-				if _, err := w.StartBox(&mp4lib.BoxInfo{Type: mp4lib.BoxTypeHdlr()}); err != nil {
+				if err := writeMeta(w, h.BoxInfo.Context, _tags, opts); err != nil {
 					return nil, err
 				}
-				hdlr := &mp4lib.Hdlr{
-					HandlerType: [4]byte{'m', 'd', 'i', 'r'},
+				// the chpl box goes into udta, next to meta
+				if tracks != nil && !tracks.hasUdta {
+					if err := tracks.writeChpl(w); err != nil {
+						return nil, err
+					}
 				}
-				if _, err := mp4lib.Marshal(w, hdlr, ctx); err != nil {
-					return nil, err
+				if !udtaWritten {
+					if err := writeUdtaText(w, _tags, opts); err != nil {
+						return nil, err
+					}
 				}
 				if _, err := w.EndBox(); err != nil {
 					return nil, err
 				}
-				if _, err := w.StartBox(&mp4lib.BoxInfo{Type: mp4lib.BoxTypeIlst()}); err != nil {
+			}
+			// a udta without meta, as cameras write it, gets the meta box
+			if len(h.Path) == 2 && h.BoxInfo.Type == mp4lib.BoxTypeUdta() && !ilstExists {
+				if err := writeMeta(w, h.BoxInfo.Context, _tags, opts); err != nil {
 					return nil, err
 				}
-				ctx.UnderIlst = true
-				if err := createAndWrite(w, ctx, _tags); err != nil {
+				ilstExists = true
+			}
+			if tracks != nil && len(h.Path) == 2 && h.BoxInfo.Type == mp4lib.BoxTypeUdta() {
+				if err := tracks.writeChpl(w); err != nil {
 					return nil, err
 				}
-				if _, err := w.EndBox(); err != nil {
+			}
+			if len(h.Path) == 2 && h.BoxInfo.Type == mp4lib.BoxTypeUdta() {
+				if err := writeUdtaText(w, _tags, opts); err != nil {
 					return nil, err
 				}
-				for range path {
-					if _, err := w.EndBox(); err != nil {
-						return nil, err
-					}
+				udtaWritten = true
+			}
+			if h.BoxInfo.Type == mp4lib.BoxTypeMoov() && !keyedWritten && len(_tags.keyedItems) > 0 {
+				if err := writeKeyedMeta(w, nil, _tags.keyedItems); err != nil {
+					return nil, err
+				}
+			}
+			if tracks != nil && h.BoxInfo.Type == mp4lib.BoxTypeMoov() {
+				if err := tracks.writeTracks(w, h.BoxInfo.Context, &tables, promote); err != nil {
+					return nil, err
 				}
 			}
 			// 1-b. [only ilst box] add metadatas
 			if h.BoxInfo.Type == mp4lib.BoxTypeIlst() {
 				ctx := h.BoxInfo.Context
 				ctx.UnderIlst = true
-				if err := createAndWrite(w, ctx, _tags); err != nil {
+				if err := createAndWrite(w, ctx, _tags, opts); err != nil {
 					return nil, err
 				}
 				ilstExists = true
 			}
-			if _, err = w.EndBox(); err != nil {
+			bi, err := w.EndBox()
+			if err != nil {
 				return nil, err
 			}
+			if isIlstPath(h.Path) {
+				if err := writeFree(w, opts.Padding); err != nil {
+					return nil, err
+				}
+			}
+			if len(h.Path) == 1 {
+				boxes = append(boxes, topBox{info: h.BoxInfo, rebuilt: true, start: bi.Offset, size: bi.Size})
+			}
 		// 2. otherwise
 		default:
-			// 2-a. [only stco box] keep offset
-			if h.BoxInfo.Type == mp4lib.BoxTypeStco() {
+			// 2-a. [only stco and co64 box] keep offset
+			if h.BoxInfo.Type == mp4lib.BoxTypeStco() || h.BoxInfo.Type == mp4lib.BoxTypeCo64() {
 				offset, _ := w.Seek(0, io.SeekCurrent)
-				stcoOffsets = append(stcoOffsets, offset)
-			}
-			// 2-b. [only mdat box] keep difference of offsets
-			if h.BoxInfo.Type == mp4lib.BoxTypeMdat() {
-				iOffset := int64(h.BoxInfo.Offset)
-				oOffset, _ := w.Seek(0, io.SeekCurrent)
-				mdatOffsetDiff = oOffset - iOffset
+				tables = append(tables, chunkOffsetTable{srcOffset: h.BoxInfo.Offset, dstOffset: offset})
+				// stco that would overflow after relocation is written as co64
+				if promote[h.BoxInfo.Offset] {
+					return nil, writeCo64(w, h)
+				}
 			}
 			// copy box without modification
 			if err := w.CopyBox(r, &h.BoxInfo); err != nil {
 				return nil, err
 			}
+			// the track to get chapters and has no tref gets one after tkhd
+			if tracks != nil && tracks.replaceChapters && len(h.Path) == 3 && h.BoxInfo.Type == mp4lib.BoxTypeTkhd() && trak == tracks.target && !tracks.targetHasTref {
+				if err := tracks.writeTref(w, trak, nil); err != nil {
+					return nil, err
+				}
+			}
 		}
 		return nil, nil
 	})
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
+	// the samples of new tracks go into mdats of their own at the end
+	if tracks != nil {
+		mdats, err := tracks.writeMdats(w)
+		if err != nil {
+			return nil, nil, err
+		}
+		boxes = append(boxes, mdats...)
+	}
+	return tables, boxes, nil
+}
 
-	ts := bufseekio.NewReadSeeker(bytes.NewReader(ws.Bytes()), 1024*1024, 3)
-
-	if _, err = ws.Seek(0, io.SeekStart); err != nil {
+// writeMeta writes a meta box holding the hdlr and the ilst built from the
+// tags, followed by the requested padding.
+func writeMeta(w mp4Writer, ctx mp4lib.Context, _tags *MP4Tag, opts *SaveOptions) error {
+	if _, err := w.StartBox(&mp4lib.BoxInfo{Type: mp4lib.BoxTypeMeta()}); err != nil {
 		return err
 	}
-	// if mdat box is moved, update stco box
-	if mdatOffsetDiff != 0 {
-		for _, stcoOffset := range stcoOffsets {
-			// seek to stco box header
-			if _, err := ts.Seek(stcoOffset, io.SeekStart); err != nil {
-				return err
-			}
-			// read box header
-			bi, err := mp4lib.ReadBoxInfo(ts)
-			if err != nil {
+	ctx.UnderUdta = true
+	if _, err := w.StartBox(&mp4lib.BoxInfo{Type: mp4lib.BoxTypeHdlr()}); err != nil {
+		return err
+	}
+	hdlr := &mp4lib.Hdlr{
+		HandlerType: [4]byte{'m', 'd', 'i', 'r'},
+	}
+	if _, err := mp4lib.Marshal(w, hdlr, ctx); err != nil {
+		return err
+	}
+	if _, err := w.EndBox(); err != nil {
+		return err
+	}
+	if _, err := w.StartBox(&mp4lib.BoxInfo{Type: mp4lib.BoxTypeIlst()}); err != nil {
+		return err
+	}
+	ctx.UnderIlst = true
+	if err := createAndWrite(w, ctx, _tags, opts); err != nil {
+		return err
+	}
+	if _, err := w.EndBox(); err != nil {
+		return err
+	}
+	if err := writeFree(w, opts.Padding); err != nil {
+		return err
+	}
+	_, err := w.EndBox()
+	return err
+}
+
+// layoutMdats computes where the payload of each mdat box lands in the output.
+func layoutMdats(boxes []topBox) []mdatMove {
+	var mdats []mdatMove
+	var offset uint64
+	for _, box := range boxes {
+		// a rebuilt mdat has a source offset past the end of the source
+		if box.info.Type == mp4lib.BoxTypeMdat() {
+			mdats = append(mdats, mdatMove{
+				srcOffset: box.info.Offset + box.info.HeaderSize,
+				dstOffset: offset + box.info.HeaderSize,
+				size:      box.info.Size - box.info.HeaderSize,
+			})
+		}
+		if box.rebuilt {
+			offset += box.size
+			continue
+		}
+		offset += box.info.Size
+	}
+	return mdats
+}
+
+// writeFile writes the top level boxes to wo. Rebuilt boxes come from moov,
+// everything else, mdat included, is streamed from r.
+func writeFile(r io.ReadSeeker, wo io.Writer, moov []byte, boxes []topBox) error {
+	buf := make([]byte, 64*1024)
+	for _, box := range boxes {
+		if box.rebuilt {
+			if _, err := wo.Write(moov[box.start : box.start+box.size]); err != nil {
 				return err
 			}
-			// read stco box payload
-			var stco mp4lib.Stco
-			_, err = mp4lib.Unmarshal(ts, bi.Size-bi.HeaderSize, &stco, bi.Context)
-			if err != nil {
-				return err
+			continue
+		}
+		if _, err := box.info.SeekToStart(r); err != nil {
+			return err
+		}
+		if n, err := io.CopyBuffer(wo, io.LimitReader(r, int64(box.info.Size)), buf); err != nil {
+			return err
+		} else if n != int64(box.info.Size) {
+			return errors.New("failed to copy box")
+		}
+	}
+	return nil
+}
+
+// writeOverFile writes the new file to a temporary file next to f and then
+// copies it over f, since f is the very file the boxes are read from.
+// The copy isn't atomic, SaveFile should be preferred when saving by path.
+func writeOverFile(f *os.File, write func(io.Writer) error) error {
+	path, err := filepath.Abs(f.Name())
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if err := write(tmp); err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	w2, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	defer w2.Close()
+	if _, err := io.Copy(w2, tmp); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	return nil
+}
+
+// writeOverSource writes the file to a temporary file and copies it over rw,
+// which is also the source. A source that gets shorter has to have a
+// Truncate method, as *os.File does, or nothing is written.
+func writeOverSource(rw io.ReadWriteSeeker, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp("", "mp4meta.*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if err := write(tmp); err != nil {
+		return err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	srcSize, err := rw.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	t, canTruncate := rw.(interface{ Truncate(size int64) error })
+	if size < srcSize && !canTruncate {
+		return fmt.Errorf("the saved file is %d bytes shorter than the source, which can't be truncated", srcSize-size)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := rw.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(rw, tmp); err != nil {
+		return err
+	}
+	if size < srcSize {
+		return t.Truncate(size)
+	}
+	return nil
+}
+
+// writeCo64 writes the stco box of h as a co64 box with the same entries.
+func writeCo64(w mp4Writer, h *mp4lib.ReadHandle) error {
+	box, _, err := h.ReadPayload()
+	if err != nil {
+		return err
+	}
+	stco := box.(*mp4lib.Stco)
+	co64 := &mp4lib.Co64{
+		EntryCount:  stco.EntryCount,
+		ChunkOffset: make([]uint64, len(stco.ChunkOffset)),
+	}
+	for i, offset := range stco.ChunkOffset {
+		co64.ChunkOffset[i] = uint64(offset)
+	}
+	if _, err := w.StartBox(&mp4lib.BoxInfo{Type: mp4lib.BoxTypeCo64()}); err != nil {
+		return err
+	}
+	if _, err := mp4lib.Marshal(w, co64, h.BoxInfo.Context); err != nil {
+		return err
+	}
+	_, err = w.EndBox()
+	return err
+}
+
+// writeBox writes box with its header.
+func writeBox(w mp4Writer, box mp4lib.IBox, ctx mp4lib.Context) error {
+	if _, err := w.StartBox(&mp4lib.BoxInfo{Type: box.GetType()}); err != nil {
+		return err
+	}
+	if _, err := mp4lib.Marshal(w, box, ctx); err != nil {
+		return err
+	}
+	_, err := w.EndBox()
+	return err
+}
+
+// writeRawBox writes a box of boxType around payload.
+func writeRawBox(w mp4Writer, boxType mp4lib.BoxType, payload []byte) error {
+	if _, err := w.StartBox(&mp4lib.BoxInfo{Type: boxType}); err != nil {
+		return err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return err
+	}
+	_, err := w.EndBox()
+	return err
+}
+
+// relocateChunkOffsets maps every offset with relocate. It reports whether a
+// relocated offset no longer fits in 32 bits, in which case a stco table has
+// to be stored as co64.
+func relocateChunkOffsets(offsets []uint64, relocate func(uint64) (uint64, error)) (bool, error) {
+	needs64 := false
+	for i, offset := range offsets {
+		newOffset, err := relocate(offset)
+		if err != nil {
+			return false, err
+		}
+		offsets[i] = newOffset
+		if newOffset > math.MaxUint32 {
+			needs64 = true
+		}
+	}
+	return needs64, nil
+}
+
+// relocateOffset maps a chunk offset of the source to the output by the mdat
+// payload it falls in.
+func relocateOffset(mdats []mdatMove) func(uint64) (uint64, error) {
+	return func(offset uint64) (uint64, error) {
+		for _, mdat := range mdats {
+			if offset >= mdat.srcOffset && offset < mdat.srcOffset+mdat.size {
+				return offset - mdat.srcOffset + mdat.dstOffset, nil
 			}
-			// update chunk offsets
-			for i := range stco.ChunkOffset {
-				stco.ChunkOffset[i] += uint32(mdatOffsetDiff)
+		}
+		return 0, fmt.Errorf("chunk offset %d is outside of any mdat box", offset)
+	}
+}
+
+// readChunkOffsetTable reads the stco or co64 box at offset. The entries are
+// returned widened to 64 bits.
+func readChunkOffsetTable(r io.ReadSeeker, offset int64) (*mp4lib.BoxInfo, mp4lib.IBox, []uint64, error) {
+	// seek to stco or co64 box header
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return nil, nil, nil, err
+	}
+	// read box header
+	bi, err := mp4lib.ReadBoxInfo(r)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// read stco or co64 box payload
+	if bi.Type == mp4lib.BoxTypeCo64() {
+		co64 := new(mp4lib.Co64)
+		if _, err := mp4lib.Unmarshal(r, bi.Size-bi.HeaderSize, co64, bi.Context); err != nil {
+			return nil, nil, nil, err
+		}
+		return bi, co64, co64.ChunkOffset, nil
+	}
+	stco := new(mp4lib.Stco)
+	if _, err := mp4lib.Unmarshal(r, bi.Size-bi.HeaderSize, stco, bi.Context); err != nil {
+		return nil, nil, nil, err
+	}
+	offsets := make([]uint64, len(stco.ChunkOffset))
+	for i, offset := range stco.ChunkOffset {
+		offsets[i] = uint64(offset)
+	}
+	return bi, stco, offsets, nil
+}
+
+// updateChunkOffsets relocates the chunk offset tables written to ws. A stco
+// table that overflows is left untouched and its source offset is returned,
+// so the caller can write it again as co64.
+func updateChunkOffsets(ws mp4WriteSeeker, tables []chunkOffsetTable, relocate func(uint64) (uint64, error)) ([]uint64, error) {
+	var overflows []uint64
+	ts := bufseekio.NewReadSeeker(bytes.NewReader(ws.Bytes()), 1024*1024, 3)
+	for _, table := range tables {
+		bi, box, offsets, err := readChunkOffsetTable(ts, table.dstOffset)
+		if err != nil {
+			return nil, err
+		}
+		// update chunk offsets
+		needs64, err := relocateChunkOffsets(offsets, relocate)
+		if err != nil {
+			return nil, err
+		}
+		if stco, ok := box.(*mp4lib.Stco); ok {
+			if needs64 {
+				overflows = append(overflows, table.srcOffset)
+				continue
 			}
-			// seek to stco box payload
-			_, err = bi.SeekToPayload(ws)
-			if err != nil {
-				return err
+			for i, offset := range offsets {
+				stco.ChunkOffset[i] = uint32(offset)
 			}
-			// write stco box payload
-			if _, err := mp4lib.Marshal(ws, &stco, bi.Context); err != nil {
-				return err
+		}
+		// seek to stco or co64 box payload
+		if _, err := bi.SeekToPayload(ws); err != nil {
+			return nil, err
+		}
+		// write stco or co64 box payload
+		if _, err := mp4lib.Marshal(ws, box, bi.Context); err != nil {
+			return nil, err
+		}
+	}
+	return overflows, nil
+}
+
+// checkChunkOffsets verifies that every chunk offset written to ws points
+// into the payload of an mdat box of the output.
+func checkChunkOffsets(ws mp4WriteSeeker, tables []chunkOffsetTable, mdats []mdatMove) error {
+	ts := bufseekio.NewReadSeeker(bytes.NewReader(ws.Bytes()), 1024*1024, 3)
+	for _, table := range tables {
+		_, _, offsets, err := readChunkOffsetTable(ts, table.dstOffset)
+		if err != nil {
+			return err
+		}
+	offsets:
+		for _, offset := range offsets {
+			for _, mdat := range mdats {
+				if offset >= mdat.dstOffset && offset < mdat.dstOffset+mdat.size {
+					continue offsets
+				}
 			}
+			return fmt.Errorf("chunk offset %d of the saved file is outside of any mdat box", offset)
 		}
 	}
-	if _, err = ws.Seek(0, io.SeekStart); err != nil {
-		return err
+	return nil
+}
+
+// saveMP4 builds the new moov box in ws and streams everything else from r,
+// so memory use is bounded by the size of moov rather than the file.
+func saveMP4(r io.ReadSeeker, wo io.Writer, w mp4Writer, ws mp4WriteSeeker, _tags *MP4Tag, opts *SaveOptions) error {
+	tracksChanged := _tags.chaptersChanged || _tags.syncedLyricsChanged
+	if opts.InPlace && isSource(r, wo) {
+		if ok, err := saveInPlace(r, wo, _tags, opts); err != nil || ok {
+			return err
+		}
 	}
-	if reflect.TypeOf(wo) == reflect.TypeOf(new(os.File)) {
-		f := wo.(*os.File)
-		path, err := filepath.Abs(f.Name())
+	var tracks *trackEdit
+	if tracksChanged {
+		movie, err := readMovie(bufseekio.NewReadSeeker(r, 1024*1024, 4))
 		if err != nil {
 			return err
 		}
-		w2, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+		tracks = newTrackEdit(movie)
+		if _tags.chaptersChanged {
+			tracks.setChapters(movie, _tags.chapters)
+		}
+		if _tags.syncedLyricsChanged {
+			tracks.setSyncedLyrics(movie, _tags.syncedLyrics)
+		}
+	}
+	var boxes []topBox
+	promote := make(map[uint64]bool)
+	for {
+		tables, top, err := writeMoov(r, w, _tags, opts, tracks, promote)
 		if err != nil {
 			return err
 		}
-		defer w2.Close()
-		if _, err = w2.Write(ws.Bytes()); err != nil {
+		boxes = top
+		// update stco and co64 boxes by the mdat box each chunk is in
+		mdats := layoutMdats(boxes)
+		overflows, err := updateChunkOffsets(ws, tables, relocateOffset(mdats))
+		if err != nil {
 			return err
 		}
-		if _, err = f.Seek(0, io.SeekEnd); err != nil {
+		if len(overflows) == 0 {
+			if err := checkChunkOffsets(ws, tables, mdats); err != nil {
+				return err
+			}
+			break
+		}
+		// promoting stco to co64 grows moov, so build it again
+		for _, offset := range overflows {
+			promote[offset] = true
+		}
+		// the writer is kept, so anything wrapping it sees the new moov too.
+		// The larger moov overwrites the old one, boxes only refer to the
+		// bytes written this time.
+		if _, err := w.Seek(0, io.SeekStart); err != nil {
 			return err
 		}
+	}
+	write := func(wo io.Writer) error {
+		return writeFile(r, wo, ws.Bytes(), boxes)
+	}
+	if f, ok := wo.(*os.File); ok && isSource(r, wo) {
+		return writeOverFile(f, write)
+	}
+	// any other source would be read and written through the same position
+	if isSource(r, wo) {
+		return writeOverSource(wo.(io.ReadWriteSeeker), write)
+	}
+	// other files, like a new file, a pipe or os.Stdout, are written directly
+	if err := write(wo); err != nil {
+		return err
+	}
+	if f, ok := wo.(*os.File); ok {
+		return truncateRest(f)
+	}
+	return nil
+}
+
+// truncateRest cuts a regular file off at the current position, so a file
+// that was longer than the one written to it keeps no stale bytes at the end.
+func truncateRest(f *os.File) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
 		return nil
 	}
-	if _, err := wo.Write(ws.Bytes()); err != nil {
+	end, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
 		return err
 	}
+	if end < fi.Size() {
+		return f.Truncate(end)
+	}
 	return nil
+}
 
+// SaveOptions controls how SaveMP4WithOptions writes a file.
+type SaveOptions struct {
+	// InPlace rewrites only the ilst box when the destination is the source
+	// file and the new ilst fits into the old one plus the free boxes right
+	// before and after it. mdat and the chunk offsets are left untouched. The
+	// file is rewritten in full when the new ilst doesn't fit. A file is
+	// synced after the ilst is written, but the write isn't atomic: a crash
+	// during it may leave a broken ilst.
+	InPlace bool
+	// Padding is the size in bytes of the free box reserved after ilst when
+	// the file is rewritten in full, so later saves can be done in place.
+	// Zero keeps whatever free boxes follow ilst already.
+	Padding int
+	// PreserveModTime keeps the modification time of the file on SaveFile.
+	PreserveModTime bool
+	// JoinMultiValues joins the values of the artist, album artist, composer
+	// and genre items into a single data box, for players that only read the
+	// first one. Empty writes a data box per value.
+	JoinMultiValues string
+	// TextAsUTF8 writes UTF-16 text values as UTF-8 and drops the locale of
+	// all text values, instead of keeping those they were read with.
+	TextAsUTF8 bool
+	// QuickTimeText also writes the title, artist, album, date, comments and
+	// other text fields as QuickTime text atoms in moov/udta, replacing the
+	// ones there. Without it only the atoms of changed fields are rewritten,
+	// or removed if the field was cleared, the others are kept as they are.
+	QuickTimeText bool
 }
 
 func SaveMP4(r io.ReadSeeker, wo io.Writer, _tags *MP4Tag) error {
+	return SaveMP4WithOptions(r, wo, _tags, SaveOptions{})
+}
+
+// SaveMP4WithOptions is SaveMP4 with control over in place updates and padding.
+// In place updates are done when wo is r, or an *os.File of the same file as r.
+// A full rewrite of r is built in a temporary file and copied over it. An
+// *os.File wo is truncated at the end of the saved file.
+func SaveMP4WithOptions(r io.ReadSeeker, wo io.Writer, _tags *MP4Tag, opts SaveOptions) error {
 	ws := &writerseeker.WriterSeeker{}
 	defer ws.Close()
 	w := mp4lib.NewWriter(ws)
-	return saveMP4(r, wo, w, ws, _tags)
+	return saveMP4(r, wo, w, ws, _tags, &opts)
 }
@@ -0,0 +1,178 @@
+package mp4meta
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	mp4lib "github.com/abema/go-mp4"
+	"github.com/aler9/writerseeker"
+	"github.com/sunfish-shogi/bufseekio"
+)
+
+// ilstSpace is the byte range taken by the mapped ilst box together with the
+// free and skip boxes right before and after it.
+type ilstSpace struct {
+	offset uint64
+	size   uint64
+}
+
+// findIlstSpace returns the space an in place update may use, or nil if the
+// file has no ilst box.
+func findIlstSpace(r io.ReadSeeker) (*ilstSpace, error) {
+	var space *ilstSpace
+	// free is the run of free boxes since the last other sibling
+	var free *ilstSpace
+	var afterIlst bool
+	rs := bufseekio.NewReadSeeker(r, 1024*1024, 4)
+	_, err := mp4lib.ReadBoxStructure(rs, func(h *mp4lib.ReadHandle) (interface{}, error) {
+		if isIlstSiblingPath(h.Path) {
+			isFree := h.BoxInfo.Type == mp4lib.BoxTypeFree() || h.BoxInfo.Type == mp4lib.BoxTypeSkip()
+			switch {
+			case isIlstPath(h.Path):
+				space = &ilstSpace{offset: h.BoxInfo.Offset, size: h.BoxInfo.Size}
+				if free != nil {
+					space.offset = free.offset
+					space.size += free.size
+				}
+				afterIlst = true
+			case afterIlst && isFree:
+				space.size += h.BoxInfo.Size
+			case isFree:
+				if free == nil {
+					free = &ilstSpace{offset: h.BoxInfo.Offset}
+				}
+				free.size += h.BoxInfo.Size
+			default:
+				free = nil
+				afterIlst = false
+			}
+			return nil, nil
+		}
+		if hasPathPrefix(ilstPath, h.Path) {
+			return h.Expand()
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return space, nil
+}
+
+// renderIlst writes the ilst box for the tags followed by a free box that
+// fills it up to size. It reports false if the ilst doesn't fit.
+func renderIlst(_tags *MP4Tag, opts *SaveOptions, size uint64) ([]byte, bool, error) {
+	ws := &writerseeker.WriterSeeker{}
+	w := mp4lib.NewWriter(ws)
+	if _, err := w.StartBox(&mp4lib.BoxInfo{Type: mp4lib.BoxTypeIlst()}); err != nil {
+		return nil, false, err
+	}
+	ctx := mp4lib.Context{UnderUdta: true, UnderIlst: true}
+	if err := createAndWrite(w, ctx, _tags, opts); err != nil {
+		return nil, false, err
+	}
+	bi, err := w.EndBox()
+	if err != nil {
+		return nil, false, err
+	}
+	// the rest has to be large enough to hold a free box header
+	if bi.Size > size || (bi.Size < size && size-bi.Size < mp4lib.SmallHeaderSize) {
+		return nil, false, nil
+	}
+	if err := writeFree(w, int(size-bi.Size)); err != nil {
+		return nil, false, err
+	}
+	return ws.Bytes(), true, nil
+}
+
+// writeFree writes a free box of size bytes, header included. Nothing is
+// written when size is too small to hold a box.
+func writeFree(w mp4Writer, size int) error {
+	if size < mp4lib.SmallHeaderSize {
+		return nil
+	}
+	if _, err := w.StartBox(&mp4lib.BoxInfo{Type: mp4lib.BoxTypeFree()}); err != nil {
+		return err
+	}
+	if _, err := w.Write(make([]byte, size-mp4lib.SmallHeaderSize)); err != nil {
+		return err
+	}
+	_, err := w.EndBox()
+	return err
+}
+
+// isSource reports whether wo writes to the file r reads from.
+func isSource(r io.ReadSeeker, wo io.Writer) bool {
+	rf, rok := r.(*os.File)
+	wf, wok := wo.(*os.File)
+	if rok && wok {
+		rfi, err := rf.Stat()
+		if err != nil {
+			return false
+		}
+		wfi, err := wf.Stat()
+		if err != nil {
+			return false
+		}
+		return os.SameFile(rfi, wfi)
+	}
+	rw, ok := wo.(io.ReadWriteSeeker)
+	return ok && io.ReadSeeker(rw) == r
+}
+
+// saveInPlace overwrites the ilst box of the source with the tags. It reports
+// false without writing anything when the new ilst doesn't fit, or when
+// boxes other than ilst have to change.
+func saveInPlace(r io.ReadSeeker, wo io.Writer, _tags *MP4Tag, opts *SaveOptions) (bool, error) {
+	// changed chapters, lyrics, keyed items or location and QuickTime text atoms need a new moov
+	if _tags.chaptersChanged || _tags.syncedLyricsChanged || _tags.keyedChanged || _tags.locationChanged || opts.QuickTimeText || _tags.anyQuickTimeTextChanged() {
+		return false, nil
+	}
+	pos, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false, err
+	}
+	space, err := findIlstSpace(r)
+	if err != nil || space == nil {
+		return false, err
+	}
+	ilst, ok, err := renderIlst(_tags, opts, space.size)
+	if err != nil || !ok {
+		return false, err
+	}
+	if f, ok := wo.(*os.File); ok {
+		// the file may be open read only, as it's the one the tags were read from
+		path, err := filepath.Abs(f.Name())
+		if err != nil {
+			return false, err
+		}
+		w2, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return false, err
+		}
+		defer w2.Close()
+		if _, err := w2.WriteAt(ilst, int64(space.offset)); err != nil {
+			return false, err
+		}
+		if err := w2.Sync(); err != nil {
+			return false, err
+		}
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	ws := wo.(io.WriteSeeker)
+	if _, err := ws.Seek(int64(space.offset), io.SeekStart); err != nil {
+		return false, err
+	}
+	if _, err := ws.Write(ilst); err != nil {
+		return false, err
+	}
+	// wo is r, its position is left where the caller had it
+	if _, err := ws.Seek(pos, io.SeekStart); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -2,13 +2,18 @@ package mp4meta
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"image"
 	"image/jpeg"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	mp4lib "github.com/abema/go-mp4"
 	"github.com/aler9/writerseeker"
@@ -482,13 +487,13 @@ func TestSaveMP4WriterErrors(t *testing.T) {
 
 	t.Run("copy box error", func(t *testing.T) {
 		mp4WriterMock := new(mockMP4Writer)
-		//mp4WriterMock.On("StartBox", mock.Anything).Return(&mp4lib.BoxInfo{}, errors.New("error starting box"))
+		mp4WriterMock.On("StartBox", mock.Anything).Return(&mp4lib.BoxInfo{}, nil)
 		mp4WriterMock.On("CopyBox", mock.Anything, mock.Anything).Return(errors.New("error copying box"))
 		buf := new(bytes.Buffer)
 		tag, err := ReadMP4(f)
 		assert.NoError(t, err)
 		tag.SetArtist("TestArtist1")
-		err = saveMP4(tag.reader, buf, mp4WriterMock, &writerseeker.WriterSeeker{}, tag)
+		err = saveMP4(tag.reader, buf, mp4WriterMock, &writerseeker.WriterSeeker{}, tag, &SaveOptions{})
 		assert.EqualError(t, err, "error copying box")
 	})
 	t.Run("moov box start error", func(t *testing.T) {
@@ -499,7 +504,7 @@ func TestSaveMP4WriterErrors(t *testing.T) {
 		tag, err := ReadMP4(f)
 		assert.NoError(t, err)
 		tag.SetArtist("TestArtist1")
-		err = saveMP4(tag.reader, buf, mp4WriterMock, &writerseeker.WriterSeeker{}, tag)
+		err = saveMP4(tag.reader, buf, mp4WriterMock, &writerseeker.WriterSeeker{}, tag, &SaveOptions{})
 		assert.EqualError(t, err, "error starting box")
 	})
 	t.Run("udta box start error", func(t *testing.T) {
@@ -511,7 +516,7 @@ func TestSaveMP4WriterErrors(t *testing.T) {
 		tag, err := ReadMP4(f)
 		assert.NoError(t, err)
 		tag.SetArtist("TestArtist1")
-		err = saveMP4(tag.reader, buf, mp4WriterMock, &writerseeker.WriterSeeker{}, tag)
+		err = saveMP4(tag.reader, buf, mp4WriterMock, &writerseeker.WriterSeeker{}, tag, &SaveOptions{})
 		assert.EqualError(t, err, "error starting box")
 	})
 	t.Run("meta box start error", func(t *testing.T) {
@@ -523,7 +528,2473 @@ func TestSaveMP4WriterErrors(t *testing.T) {
 		tag, err := ReadMP4(f)
 		assert.NoError(t, err)
 		tag.SetArtist("TestArtist1")
-		err = saveMP4(tag.reader, buf, mp4WriterMock, &writerseeker.WriterSeeker{}, tag)
+		err = saveMP4(tag.reader, buf, mp4WriterMock, &writerseeker.WriterSeeker{}, tag, &SaveOptions{})
 		assert.EqualError(t, err, "error starting box")
 	})
 }
+
+func TestUnknownAtomsM4A(t *testing.T) {
+	b, err := os.ReadFile("./testdata/test1.m4a")
+	assert.NoError(t, err)
+	tag, err := ReadMP4(bytes.NewReader(b))
+	assert.NoError(t, err)
+	xid := mp4lib.StrToBoxType("xid ")
+	tag.unknownAtoms = append(tag.unknownAtoms, Atom{
+		Type: xid,
+		Data: []byte{0, 0, 0, 0x16, 'd', 'a', 't', 'a', 0, 0, 0, 1, 0, 0, 0, 0, 'x', ':', 'i', 'd', ':', '1'},
+	})
+	before := tag.GetUnknownAtoms()
+
+	t.Run("kept on save", func(t *testing.T) {
+		tag.SetTitle("TestTitle1")
+		buffy := new(bytes.Buffer)
+		err := tag.Save(buffy)
+		assert.NoError(t, err)
+		tag, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+		assert.NoError(t, err)
+		assert.Equal(t, "TestTitle1", tag.GetTitle())
+		assert.Equal(t, before, tag.GetUnknownAtoms())
+	})
+
+	t.Run("removed on request", func(t *testing.T) {
+		tag.RemoveUnknownAtom(xid)
+		buffy := new(bytes.Buffer)
+		err := tag.Save(buffy)
+		assert.NoError(t, err)
+		tag, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+		assert.NoError(t, err)
+		for _, atom := range tag.GetUnknownAtoms() {
+			assert.NotEqual(t, xid, atom.Type)
+		}
+	})
+}
+
+func TestFreeformM4A(t *testing.T) {
+	b, err := os.ReadFile("./testdata/test1.m4a")
+	assert.NoError(t, err)
+	tag, err := ReadMP4(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Len(t, tag.GetFreeforms(), 3)
+	assert.Contains(t, tag.GetFreeform(FreeformMeanITunes, "iTunNORM"), "00001769")
+
+	tag.SetFreeform(FreeformMeanITunes, "MusicBrainz Album Id", "1b2c3d4e")
+	tag.SetFreeform(FreeformMeanITunes, "iTunNORM", "replaced")
+	tag.RemoveFreeform(FreeformMeanITunes, "iTunSMPB")
+	buffy := new(bytes.Buffer)
+	err = tag.Save(buffy)
+	assert.NoError(t, err)
+
+	tag, err = ReadMP4(bytes.NewReader(buffy.Bytes()))
+	assert.NoError(t, err)
+	assert.Len(t, tag.GetFreeforms(), 3)
+	assert.Equal(t, "1b2c3d4e", tag.GetFreeform(FreeformMeanITunes, "MusicBrainz Album Id"))
+	assert.Equal(t, "replaced", tag.GetFreeform(FreeformMeanITunes, "iTunNORM"))
+	assert.Empty(t, tag.GetFreeform(FreeformMeanITunes, "iTunSMPB"))
+	assert.NotEmpty(t, tag.GetFreeform(FreeformMeanITunes, "Encoding Params"))
+
+	// a "----" item as Picard writes it, with a data box per value
+	item := func(name string, values ...FreeformValue) []byte {
+		payload := append(boxBytes("mean", u32(0), []byte(FreeformMeanITunes)), boxBytes("name", u32(0), []byte(name))...)
+		for _, value := range values {
+			payload = append(payload, boxBytes("data", u32(value.DataType), u32(value.Locale), value.Data)...)
+		}
+		return payload
+	}
+	artistIDs := []FreeformValue{
+		{DataType: mp4lib.DataTypeStringUTF8, Data: []byte("id-1")},
+		{DataType: mp4lib.DataTypeStringUTF8, Locale: uint32(PackLanguage("eng")), Data: []byte("id-2")},
+	}
+
+	t.Run("multiple values", func(t *testing.T) {
+		tag, err := ReadMP4(bytes.NewReader(b))
+		assert.NoError(t, err)
+		tag.unknownAtoms = append(tag.unknownAtoms, Atom{Type: boxTypeFreeform, Data: item("MusicBrainz Artist Id", artistIDs...)})
+		buffy := new(bytes.Buffer)
+		err = tag.Save(buffy)
+		assert.NoError(t, err)
+		saved, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+		assert.NoError(t, err)
+		assert.Empty(t, saved.GetUnknownAtoms())
+		assert.Equal(t, "id-1", saved.GetFreeform(FreeformMeanITunes, "MusicBrainz Artist Id"))
+		freeforms := saved.GetFreeforms()
+		assert.Equal(t, Freeform{Mean: FreeformMeanITunes, Name: "MusicBrainz Artist Id", Values: artistIDs}, freeforms[len(freeforms)-1])
+	})
+
+	t.Run("unparsed items replaced", func(t *testing.T) {
+		tag, err := ReadMP4(bytes.NewReader(b))
+		assert.NoError(t, err)
+		// two names can't be parsed, the item is kept as it is
+		broken := append(item("MusicBrainz Album Id", artistIDs[0]), boxBytes("name", u32(0), []byte("other"))...)
+		tag.unknownAtoms = append(tag.unknownAtoms, Atom{Type: boxTypeFreeform, Data: broken})
+		tag.SetFreeform(FreeformMeanITunes, "MusicBrainz Album Id", "new")
+		assert.Empty(t, tag.GetUnknownAtoms())
+		tag.unknownAtoms = append(tag.unknownAtoms, Atom{Type: boxTypeFreeform, Data: broken})
+		tag.RemoveFreeform(FreeformMeanITunes, "MusicBrainz Album Id")
+		assert.Empty(t, tag.GetUnknownAtoms())
+		assert.Empty(t, tag.GetFreeform(FreeformMeanITunes, "MusicBrainz Album Id"))
+	})
+}
+
+// toCo64 rewrites an mp4 file with co64 chunk offset tables and a largesize
+// mdat header.
+func toCo64(t *testing.T, b []byte) []byte {
+	ws := &writerseeker.WriterSeeker{}
+	w := mp4lib.NewWriter(ws)
+	var tables []int64
+	var diff int64
+	_, err := mp4lib.ReadBoxStructure(bytes.NewReader(b), func(h *mp4lib.ReadHandle) (interface{}, error) {
+		switch h.BoxInfo.Type {
+		case mp4lib.BoxTypeMoov(), mp4lib.BoxTypeTrak(), mp4lib.BoxTypeMdia(), mp4lib.BoxTypeMinf(), mp4lib.BoxTypeStbl():
+			if _, err := w.StartBox(&h.BoxInfo); err != nil {
+				return nil, err
+			}
+			if _, err := h.Expand(); err != nil {
+				return nil, err
+			}
+			_, err := w.EndBox()
+			return nil, err
+		case mp4lib.BoxTypeStco():
+			box, _, err := h.ReadPayload()
+			if err != nil {
+				return nil, err
+			}
+			co64 := &mp4lib.Co64{EntryCount: box.(*mp4lib.Stco).EntryCount}
+			for _, offset := range box.(*mp4lib.Stco).ChunkOffset {
+				co64.ChunkOffset = append(co64.ChunkOffset, uint64(offset))
+			}
+			offset, _ := w.Seek(0, io.SeekCurrent)
+			tables = append(tables, offset)
+			if _, err := w.StartBox(&mp4lib.BoxInfo{Type: mp4lib.BoxTypeCo64()}); err != nil {
+				return nil, err
+			}
+			if _, err := mp4lib.Marshal(w, co64, h.BoxInfo.Context); err != nil {
+				return nil, err
+			}
+			_, err = w.EndBox()
+			return nil, err
+		case mp4lib.BoxTypeMdat():
+			offset, _ := w.Seek(0, io.SeekCurrent)
+			diff = offset + mp4lib.LargeHeaderSize - int64(h.BoxInfo.Offset+h.BoxInfo.HeaderSize)
+			bi := &mp4lib.BoxInfo{
+				Type:       mp4lib.BoxTypeMdat(),
+				Size:       h.BoxInfo.Size - h.BoxInfo.HeaderSize + mp4lib.LargeHeaderSize,
+				HeaderSize: mp4lib.LargeHeaderSize,
+			}
+			if _, err := w.StartBox(bi); err != nil {
+				return nil, err
+			}
+			if _, err := h.ReadData(w); err != nil {
+				return nil, err
+			}
+			_, err := w.EndBox()
+			return nil, err
+		default:
+			return nil, w.CopyBox(bytes.NewReader(b), &h.BoxInfo)
+		}
+	})
+	assert.NoError(t, err)
+	out := append([]byte(nil), ws.Bytes()...)
+	for _, offset := range tables {
+		bis, err := mp4lib.ExtractBoxWithPayload(bytes.NewReader(out), nil, mp4lib.BoxPath{mp4lib.BoxTypeMoov(), mp4lib.BoxTypeTrak(), mp4lib.BoxTypeMdia(), mp4lib.BoxTypeMinf(), mp4lib.BoxTypeStbl(), mp4lib.BoxTypeCo64()})
+		assert.NoError(t, err)
+		for _, bi := range bis {
+			if int64(bi.Info.Offset) != offset {
+				continue
+			}
+			co64 := bi.Payload.(*mp4lib.Co64)
+			for i := range co64.ChunkOffset {
+				co64.ChunkOffset[i] += uint64(diff)
+			}
+			ow := &writerseeker.WriterSeeker{}
+			_, err := mp4lib.Marshal(ow, co64, bi.Info.Context)
+			assert.NoError(t, err)
+			copy(out[bi.Info.Offset+bi.Info.HeaderSize:], ow.Bytes())
+		}
+	}
+	return out
+}
+
+// chunkOffsets returns the stco and co64 entries of all tracks.
+func chunkOffsets(t *testing.T, b []byte) []uint64 {
+	stbl := mp4lib.BoxPath{mp4lib.BoxTypeMoov(), mp4lib.BoxTypeTrak(), mp4lib.BoxTypeMdia(), mp4lib.BoxTypeMinf(), mp4lib.BoxTypeStbl()}
+	bis, err := mp4lib.ExtractBoxesWithPayload(bytes.NewReader(b), nil, []mp4lib.BoxPath{
+		append(append(mp4lib.BoxPath{}, stbl...), mp4lib.BoxTypeStco()),
+		append(append(mp4lib.BoxPath{}, stbl...), mp4lib.BoxTypeCo64()),
+	})
+	assert.NoError(t, err)
+	var offsets []uint64
+	for _, bi := range bis {
+		switch box := bi.Payload.(type) {
+		case *mp4lib.Stco:
+			for _, offset := range box.ChunkOffset {
+				offsets = append(offsets, uint64(offset))
+			}
+		case *mp4lib.Co64:
+			offsets = append(offsets, box.ChunkOffset...)
+		}
+	}
+	return offsets
+}
+
+// assertSameChunks checks that the chunks of two files start with the same bytes.
+func assertSameChunks(t *testing.T, expected, actual []byte) {
+	eOffsets := chunkOffsets(t, expected)
+	aOffsets := chunkOffsets(t, actual)
+	assert.Equal(t, len(eOffsets), len(aOffsets))
+	for i := range eOffsets {
+		assert.Equal(t, expected[eOffsets[i]:eOffsets[i]+16], actual[aOffsets[i]:aOffsets[i]+16])
+	}
+}
+
+func TestChunkOffsetsM4A(t *testing.T) {
+	src, err := os.ReadFile("./testdata/testdata-m4a-nonEmpty.m4a")
+	assert.NoError(t, err)
+
+	t.Run("stco", func(t *testing.T) {
+		tag, err := ReadMP4(bytes.NewReader(src))
+		assert.NoError(t, err)
+		tag.SetComments(string(make([]byte, 40000)))
+		buffy := new(bytes.Buffer)
+		err = tag.Save(buffy)
+		assert.NoError(t, err)
+		assertSameChunks(t, src, buffy.Bytes())
+	})
+
+	t.Run("co64 with largesize mdat", func(t *testing.T) {
+		b := toCo64(t, src)
+		assertSameChunks(t, src, b)
+		tag, err := ReadMP4(bytes.NewReader(b))
+		assert.NoError(t, err)
+		tag.SetComments(string(make([]byte, 40000)))
+		buffy := new(bytes.Buffer)
+		err = tag.Save(buffy)
+		assert.NoError(t, err)
+		assertSameChunks(t, src, buffy.Bytes())
+	})
+
+	t.Run("stco overflow needs co64", func(t *testing.T) {
+		offsets := []uint64{math.MaxUint32 - 200, math.MaxUint32 - 100}
+		needs64, err := relocateChunkOffsets(offsets, func(offset uint64) (uint64, error) {
+			return offset + 150, nil
+		})
+		assert.NoError(t, err)
+		assert.True(t, needs64)
+		assert.Equal(t, []uint64{math.MaxUint32 - 50, math.MaxUint32 + 50}, offsets)
+	})
+}
+
+// splitMdat rewrites an mp4 file whose moov is followed by a single mdat so
+// that the first chunks are in an mdat before moov and the rest in an mdat
+// after it.
+func splitMdat(t *testing.T, b []byte) []byte {
+	bis, err := mp4lib.ExtractBoxes(bytes.NewReader(b), nil, []mp4lib.BoxPath{
+		{mp4lib.BoxTypeFtyp()},
+		{mp4lib.BoxTypeMoov()},
+		{mp4lib.BoxTypeMdat()},
+		{mp4lib.BoxTypeMoov(), mp4lib.BoxTypeTrak(), mp4lib.BoxTypeMdia(), mp4lib.BoxTypeMinf(), mp4lib.BoxTypeStbl(), mp4lib.BoxTypeStco()},
+	})
+	assert.NoError(t, err)
+	var ftyp, moov, mdat, stco *mp4lib.BoxInfo
+	for _, bi := range bis {
+		switch bi.Type {
+		case mp4lib.BoxTypeFtyp():
+			ftyp = bi
+		case mp4lib.BoxTypeMoov():
+			moov = bi
+		case mp4lib.BoxTypeMdat():
+			mdat = bi
+		case mp4lib.BoxTypeStco():
+			stco = bi
+		}
+	}
+	offsets := chunkOffsets(t, b)
+	payload := b[mdat.Offset+mdat.HeaderSize : mdat.Offset+mdat.Size]
+	split := offsets[len(offsets)/2] - (mdat.Offset + mdat.HeaderSize)
+
+	out := append([]byte(nil), b[ftyp.Offset:ftyp.Offset+ftyp.Size]...)
+	out = append(out, boxBytes("mdat", payload[:split])...)
+	moovOffset := uint64(len(out))
+	out = append(out, b[moov.Offset:moov.Offset+moov.Size]...)
+	out = append(out, boxBytes("mdat", payload[split:])...)
+
+	entries := out[moovOffset+stco.Offset-moov.Offset+stco.HeaderSize+8:]
+	for i, offset := range offsets {
+		rel := offset - (mdat.Offset + mdat.HeaderSize)
+		if rel < split {
+			offset = ftyp.Size + 8 + rel
+		} else {
+			offset = moovOffset + moov.Size + 8 + rel - split
+		}
+		binary.BigEndian.PutUint32(entries[4*i:], uint32(offset))
+	}
+	return out
+}
+
+func TestMultipleMdatM4A(t *testing.T) {
+	src, err := os.ReadFile("./testdata/testdata-m4a-nonEmpty.m4a")
+	assert.NoError(t, err)
+	b := splitMdat(t, src)
+	assertSameChunks(t, src, b)
+
+	t.Run("relocated per mdat", func(t *testing.T) {
+		tag, err := ReadMP4(bytes.NewReader(b))
+		assert.NoError(t, err)
+		tag.SetComments(string(make([]byte, 40000)))
+		buffy := new(bytes.Buffer)
+		err = tag.Save(buffy)
+		assert.NoError(t, err)
+		assertSameChunks(t, src, buffy.Bytes())
+	})
+
+	t.Run("offset outside of mdat", func(t *testing.T) {
+		broken := append([]byte(nil), b...)
+		bis, err := mp4lib.ExtractBox(bytes.NewReader(broken), nil, mp4lib.BoxPath{mp4lib.BoxTypeMoov(), mp4lib.BoxTypeTrak(), mp4lib.BoxTypeMdia(), mp4lib.BoxTypeMinf(), mp4lib.BoxTypeStbl(), mp4lib.BoxTypeStco()})
+		assert.NoError(t, err)
+		binary.BigEndian.PutUint32(broken[bis[0].Offset+bis[0].HeaderSize+8:], 4)
+		tag, err := ReadMP4(bytes.NewReader(broken))
+		assert.NoError(t, err)
+		err = tag.Save(new(bytes.Buffer))
+		assert.EqualError(t, err, "chunk offset 4 is outside of any mdat box")
+	})
+}
+
+var zeros [64 * 1024]byte
+
+// sparseFile is a read-only file made of prefix followed by zeros up to size.
+type sparseFile struct {
+	prefix []byte
+	size   int64
+	pos    int64
+}
+
+func (f *sparseFile) Read(p []byte) (int, error) {
+	if f.pos >= f.size {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > f.size-f.pos {
+		p = p[:f.size-f.pos]
+	}
+	n := 0
+	if f.pos < int64(len(f.prefix)) {
+		n = copy(p, f.prefix[f.pos:])
+	}
+	for i := n; i < len(p); {
+		i += copy(p[i:], zeros[:])
+	}
+	f.pos += int64(len(p))
+	return len(p), nil
+}
+
+func (f *sparseFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+// headWriter keeps the first bytes written to it and counts the rest.
+type headWriter struct {
+	head []byte
+	n    int64
+}
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	if room := cap(w.head) - len(w.head); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		w.head = append(w.head, p[:room]...)
+	}
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// bigFile returns the fixture with its mdat grown to size bytes by zeros and
+// a largesize mdat header. The last chunk offset is moved to lastChunk.
+func bigFile(t *testing.T, size int64, lastChunk uint32) *sparseFile {
+	src, err := os.ReadFile("./testdata/testdata-m4a-nonEmpty.m4a")
+	assert.NoError(t, err)
+	bis, err := mp4lib.ExtractBoxes(bytes.NewReader(src), nil, []mp4lib.BoxPath{
+		{mp4lib.BoxTypeMdat()},
+		{mp4lib.BoxTypeMoov(), mp4lib.BoxTypeTrak(), mp4lib.BoxTypeMdia(), mp4lib.BoxTypeMinf(), mp4lib.BoxTypeStbl(), mp4lib.BoxTypeStco()},
+	})
+	assert.NoError(t, err)
+	mdat, stco := bis[1], bis[0]
+	if mdat.Type != mp4lib.BoxTypeMdat() {
+		mdat, stco = stco, mdat
+	}
+	prefix := append([]byte(nil), src[:mdat.Offset]...)
+	header := make([]byte, mp4lib.LargeHeaderSize)
+	binary.BigEndian.PutUint32(header, 1)
+	copy(header[4:], "mdat")
+	binary.BigEndian.PutUint64(header[8:], uint64(size)-mdat.Offset)
+	prefix = append(prefix, header...)
+	prefix = append(prefix, src[mdat.Offset+mdat.HeaderSize:]...)
+	entries := prefix[stco.Offset+stco.HeaderSize+8:]
+	count := int(binary.BigEndian.Uint32(prefix[stco.Offset+stco.HeaderSize+4:]))
+	for i := 0; i < count; i++ {
+		offset := binary.BigEndian.Uint32(entries[4*i:])
+		binary.BigEndian.PutUint32(entries[4*i:], offset+mp4lib.LargeHeaderSize-mp4lib.SmallHeaderSize)
+	}
+	binary.BigEndian.PutUint32(entries[4*(count-1):], lastChunk)
+	return &sparseFile{prefix: prefix, size: size}
+}
+
+func TestLargeFileM4A(t *testing.T) {
+	t.Run("memory bounded by moov", func(t *testing.T) {
+		const size = 256 * 1024 * 1024
+		src := bigFile(t, size, 200*1024*1024)
+		tag, err := ReadMP4(src)
+		assert.NoError(t, err)
+		tag.SetTitle("TestTitle1")
+
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		out := &headWriter{head: make([]byte, 0, 64*1024)}
+		err = SaveMP4(src, out, tag)
+		assert.NoError(t, err)
+		runtime.ReadMemStats(&after)
+		assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(32*1024*1024))
+		assert.Greater(t, out.n, int64(size-1024*1024))
+	})
+
+	t.Run("stco promoted to co64 past 4GiB", func(t *testing.T) {
+		if testing.Short() {
+			t.Skip("writes more than 4GiB")
+		}
+		const size = math.MaxUint32 + 1024*1024
+		src := bigFile(t, size, math.MaxUint32-16)
+		tag, err := ReadMP4(src)
+		assert.NoError(t, err)
+		tag.SetComments(string(make([]byte, 1024)))
+		out := &headWriter{head: make([]byte, 0, 64*1024)}
+		err = SaveMP4(src, out, tag)
+		assert.NoError(t, err)
+		offsets := chunkOffsets(t, out.head)
+		assert.Greater(t, offsets[len(offsets)-1], uint64(math.MaxUint32))
+		assert.Less(t, offsets[len(offsets)-1], uint64(out.n))
+	})
+
+	t.Run("promotion keeps the writer", func(t *testing.T) {
+		if testing.Short() {
+			t.Skip("writes more than 4GiB")
+		}
+		const size = math.MaxUint32 + 1024*1024
+		src := bigFile(t, size, math.MaxUint32-16)
+		tag, err := ReadMP4(src)
+		assert.NoError(t, err)
+		tag.SetComments(string(make([]byte, 1024)))
+		ws := &writerseeker.WriterSeeker{}
+		w := &countingWriter{Writer: mp4lib.NewWriter(ws)}
+		out := &headWriter{head: make([]byte, 0, 64*1024)}
+		err = saveMP4(src, out, w, ws, tag, &SaveOptions{})
+		assert.NoError(t, err)
+		// moov is built twice, the second time with co64
+		assert.Equal(t, 2, w.boxes[mp4lib.BoxTypeMoov()])
+		assert.Equal(t, 1, w.boxes[mp4lib.BoxTypeCo64()])
+		offsets := chunkOffsets(t, out.head)
+		assert.Greater(t, offsets[len(offsets)-1], uint64(math.MaxUint32))
+	})
+}
+
+// countingWriter counts the boxes started and copied through it.
+type countingWriter struct {
+	*mp4lib.Writer
+	boxes map[mp4lib.BoxType]int
+}
+
+func (w *countingWriter) StartBox(bi *mp4lib.BoxInfo) (*mp4lib.BoxInfo, error) {
+	if w.boxes == nil {
+		w.boxes = map[mp4lib.BoxType]int{}
+	}
+	w.boxes[bi.Type]++
+	return w.Writer.StartBox(bi)
+}
+
+func TestSaveInPlaceM4A(t *testing.T) {
+	err := os.Mkdir("./testdata/temp", 0755)
+	if err != nil {
+		assert.EqualError(t, err, "mkdir ./testdata/temp: file exists")
+	}
+	defer os.RemoveAll("./testdata/temp")
+	of, err := os.ReadFile("./testdata/testdata-m4a-nonEmpty.m4a")
+	assert.NoError(t, err)
+	err = os.WriteFile("./testdata/temp/testdata-m4a-nonEmpty.m4a", of, 0755)
+	assert.NoError(t, err)
+	path, _ := filepath.Abs("./testdata/temp/testdata-m4a-nonEmpty.m4a")
+
+	save := func(t *testing.T, opts SaveOptions, edit func(tag *MP4Tag)) []byte {
+		f, err := os.Open(path)
+		assert.NoError(t, err)
+		defer f.Close()
+		tag, err := ReadMP4(f)
+		assert.NoError(t, err)
+		edit(tag)
+		err = tag.SaveWithOptions(f, opts)
+		assert.NoError(t, err)
+		b, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		return b
+	}
+
+	t.Run("fits into free space", func(t *testing.T) {
+		b := save(t, SaveOptions{InPlace: true}, func(tag *MP4Tag) {
+			tag.SetTitle("TestTitle1")
+			tag.SetComments(string(make([]byte, 1000)))
+		})
+		assert.Equal(t, len(of), len(b))
+		assert.Equal(t, chunkOffsets(t, of), chunkOffsets(t, b))
+		tag, err := ReadMP4(bytes.NewReader(b))
+		assert.NoError(t, err)
+		assert.Equal(t, "TestTitle1", tag.GetTitle())
+		assert.Equal(t, "test1", tag.GetArtist())
+	})
+
+	t.Run("full rewrite with padding", func(t *testing.T) {
+		b := save(t, SaveOptions{InPlace: true, Padding: 8192}, func(tag *MP4Tag) {
+			tag.SetComments(string(make([]byte, 4000)))
+		})
+		assert.Greater(t, len(b), len(of))
+		assertSameChunks(t, of, b)
+		bis, err := mp4lib.ExtractBox(bytes.NewReader(b), nil, mp4lib.BoxPath{mp4lib.BoxTypeMoov(), mp4lib.BoxTypeUdta(), mp4lib.BoxTypeMeta(), mp4lib.BoxTypeFree()})
+		assert.NoError(t, err)
+		assert.Len(t, bis, 1)
+		assert.Equal(t, uint64(8192), bis[0].Size)
+
+		c := save(t, SaveOptions{InPlace: true}, func(tag *MP4Tag) {
+			tag.SetComments(string(make([]byte, 8000)))
+		})
+		assert.Equal(t, len(b), len(c))
+		assert.Equal(t, chunkOffsets(t, b), chunkOffsets(t, c))
+		tag, err := ReadMP4(bytes.NewReader(c))
+		assert.NoError(t, err)
+		assert.Len(t, tag.GetComments(), 8000)
+	})
+
+	t.Run("free space before ilst", func(t *testing.T) {
+		// move the free box after ilst in front of it
+		bis, err := mp4lib.ExtractBoxes(bytes.NewReader(of), nil, []mp4lib.BoxPath{
+			{mp4lib.BoxTypeMoov(), mp4lib.BoxTypeUdta(), mp4lib.BoxTypeMeta(), mp4lib.BoxTypeIlst()},
+			{mp4lib.BoxTypeMoov(), mp4lib.BoxTypeUdta(), mp4lib.BoxTypeMeta(), mp4lib.BoxTypeFree()},
+		})
+		assert.NoError(t, err)
+		assert.Len(t, bis, 2)
+		ilst, free := bis[0], bis[1]
+		assert.Equal(t, ilst.Offset+ilst.Size, free.Offset)
+		src := append([]byte(nil), of[:ilst.Offset]...)
+		src = append(src, of[free.Offset:free.Offset+free.Size]...)
+		src = append(src, of[ilst.Offset:free.Offset]...)
+		src = append(src, of[free.Offset+free.Size:]...)
+
+		f := &memFile{b: src}
+		tag, err := ReadMP4(f)
+		assert.NoError(t, err)
+		tag.SetComments(string(make([]byte, 1500)))
+		_, err = f.Seek(100, io.SeekStart)
+		assert.NoError(t, err)
+		err = tag.SaveWithOptions(f, SaveOptions{InPlace: true})
+		assert.NoError(t, err)
+		assert.Equal(t, len(src), len(f.b))
+		assert.Equal(t, chunkOffsets(t, src), chunkOffsets(t, f.b))
+		assert.Equal(t, int64(100), f.pos)
+		tag, err = ReadMP4(bytes.NewReader(f.b))
+		assert.NoError(t, err)
+		assert.Len(t, tag.GetComments(), 1500)
+		assert.Equal(t, "test1", tag.GetArtist())
+	})
+
+	t.Run("full rewrite of a reader", func(t *testing.T) {
+		f := &memFile{b: append([]byte(nil), of...)}
+		tag, err := ReadMP4(f)
+		assert.NoError(t, err)
+		tag.SetComments(strings.Repeat("a", 200000))
+		err = tag.SaveWithOptions(f, SaveOptions{InPlace: true})
+		assert.NoError(t, err)
+		assertSameChunks(t, of, f.b)
+		saved, err := ReadMP4(bytes.NewReader(f.b))
+		assert.NoError(t, err)
+		assert.Len(t, saved.GetComments(), 200000)
+		assert.Equal(t, "test1", saved.GetArtist())
+
+		// it can't be made shorter without a Truncate method
+		grown := append([]byte(nil), f.b...)
+		_, err = f.Seek(0, io.SeekStart)
+		assert.NoError(t, err)
+		tag, err = ReadMP4(f)
+		assert.NoError(t, err)
+		tag.SetComments("")
+		err = tag.SaveWithOptions(f, SaveOptions{})
+		assert.Error(t, err)
+		assert.Equal(t, grown, f.b)
+	})
+}
+
+// memFile is an in memory io.ReadWriteSeeker.
+type memFile struct {
+	b   []byte
+	pos int64
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	if f.pos >= int64(len(f.b)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.b[f.pos:])
+	f.pos += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if end := f.pos + int64(len(p)); end > int64(len(f.b)) {
+		f.b = append(f.b, make([]byte, end-int64(len(f.b)))...)
+	}
+	n := copy(f.b[f.pos:], p)
+	f.pos += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += int64(len(f.b))
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func TestSaveFileM4A(t *testing.T) {
+	err := os.Mkdir("./testdata/temp", 0755)
+	if err != nil {
+		assert.EqualError(t, err, "mkdir ./testdata/temp: file exists")
+	}
+	defer os.RemoveAll("./testdata/temp")
+	of, err := os.ReadFile("./testdata/testdata-m4a-nonEmpty.m4a")
+	assert.NoError(t, err)
+	path := "./testdata/temp/testdata-m4a-nonEmpty.m4a"
+	err = os.WriteFile(path, of, 0640)
+	assert.NoError(t, err)
+	err = os.Chmod(path, 0640)
+	assert.NoError(t, err)
+	mtime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	err = os.Chtimes(path, mtime, mtime)
+	assert.NoError(t, err)
+
+	t.Run("keeps mode and mtime", func(t *testing.T) {
+		tag, err := ReadFile(path)
+		assert.NoError(t, err)
+		tag.SetComments(string(make([]byte, 4000)))
+		err = SaveFile(path, tag, SaveOptions{PreserveModTime: true})
+		assert.NoError(t, err)
+
+		fi, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+		assert.True(t, mtime.Equal(fi.ModTime()))
+		tag, err = ReadFile(path)
+		assert.NoError(t, err)
+		assert.Len(t, tag.GetComments(), 4000)
+		assert.Equal(t, "test1", tag.GetTitle())
+		entries, err := os.ReadDir("./testdata/temp")
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("no reader to save from", func(t *testing.T) {
+		tag, err := ReadFile(path)
+		assert.NoError(t, err)
+		tag.SetTitle("TestTitle1")
+		err = tag.Save(new(bytes.Buffer))
+		assert.Error(t, err)
+		err = tag.SaveWithOptions(new(bytes.Buffer), SaveOptions{InPlace: true})
+		assert.Error(t, err)
+	})
+
+	t.Run("waits for lock", func(t *testing.T) {
+		tag, err := ReadFile(path)
+		assert.NoError(t, err)
+		tag.SetTitle("TestTitle1")
+		unlock, err := lockPath(path)
+		assert.NoError(t, err)
+		done := make(chan error)
+		go func() {
+			done <- SaveFile(path, tag, SaveOptions{})
+		}()
+		select {
+		case <-done:
+			t.Fatal("saved while the file was locked")
+		case <-time.After(200 * time.Millisecond):
+		}
+		unlock()
+		assert.NoError(t, <-done)
+		tag, err = ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, "TestTitle1", tag.GetTitle())
+	})
+
+	t.Run("in place falls back for chapters", func(t *testing.T) {
+		tag, err := ReadFile(path)
+		assert.NoError(t, err)
+		err = SaveFile(path, tag, SaveOptions{Padding: 4096})
+		assert.NoError(t, err)
+		tag, err = ReadFile(path)
+		assert.NoError(t, err)
+		tag.SetChapters([]Chapter{{Title: "One"}, {Start: time.Second, Title: "Two"}})
+		err = SaveFile(path, tag, SaveOptions{InPlace: true})
+		assert.NoError(t, err)
+		tag, err = ReadFile(path)
+		assert.NoError(t, err)
+		assert.Len(t, tag.GetChapters(), 2)
+	})
+
+	t.Run("other files are written directly", func(t *testing.T) {
+		src, err := os.Open(path)
+		assert.NoError(t, err)
+		defer src.Close()
+		tag, err := ReadMP4(src)
+		assert.NoError(t, err)
+		tag.SetTitle("TestTitle2")
+
+		pr, pw, err := os.Pipe()
+		assert.NoError(t, err)
+		piped := make(chan []byte)
+		go func() {
+			b, _ := io.ReadAll(pr)
+			piped <- b
+		}()
+		err = tag.Save(pw)
+		assert.NoError(t, err)
+		pw.Close()
+		b := <-piped
+		pr.Close()
+		tag, err = ReadMP4(bytes.NewReader(b))
+		assert.NoError(t, err)
+		assert.Equal(t, "TestTitle2", tag.GetTitle())
+
+		// a separate file is written at its position, without a temporary copy
+		out, err := os.OpenFile("./testdata/temp/out.m4a", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		assert.NoError(t, err)
+		defer out.Close()
+		_, err = out.Write([]byte("head"))
+		assert.NoError(t, err)
+		tag, err = ReadMP4(src)
+		assert.NoError(t, err)
+		tag.SetTitle("TestTitle2")
+		err = tag.Save(out)
+		assert.NoError(t, err)
+		saved, err := os.ReadFile("./testdata/temp/out.m4a")
+		assert.NoError(t, err)
+		assert.Equal(t, "head", string(saved[:4]))
+		assert.Equal(t, len(b), len(saved)-4)
+		tag, err = ReadMP4(bytes.NewReader(saved[4:]))
+		assert.NoError(t, err)
+		assert.Equal(t, "TestTitle2", tag.GetTitle())
+
+		// a longer file opened without O_TRUNC is cut off at the end
+		existing, err := os.OpenFile("./testdata/temp/out.m4a", os.O_WRONLY, 0)
+		assert.NoError(t, err)
+		defer existing.Close()
+		tag, err = ReadMP4(src)
+		assert.NoError(t, err)
+		tag.SetTitle("TestTitle2")
+		err = tag.Save(existing)
+		assert.NoError(t, err)
+		saved, err = os.ReadFile("./testdata/temp/out.m4a")
+		assert.NoError(t, err)
+		assert.Equal(t, len(b), len(saved))
+		tag, err = ReadMP4(bytes.NewReader(saved))
+		assert.NoError(t, err)
+		assert.Equal(t, "TestTitle2", tag.GetTitle())
+		entries, err := os.ReadDir("./testdata/temp")
+		assert.NoError(t, err)
+		assert.Len(t, entries, 2)
+	})
+
+	t.Run("stale lock file", func(t *testing.T) {
+		// left behind by a process that died while saving
+		lock := path + ".lock"
+		err := os.WriteFile(lock, nil, 0600)
+		assert.NoError(t, err)
+		defer os.Remove(lock)
+		old := time.Now().Add(-time.Hour)
+		err = os.Chtimes(lock, old, old)
+		assert.NoError(t, err)
+		tag, err := ReadFile(path)
+		assert.NoError(t, err)
+		tag.SetTitle("TestTitle3")
+		start := time.Now()
+		err = SaveFile(path, tag, SaveOptions{})
+		assert.NoError(t, err)
+		assert.Less(t, time.Since(start), 5*time.Second)
+		tag, err = ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, "TestTitle3", tag.GetTitle())
+	})
+
+	t.Run("keeps special mode bits", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("no setuid and sticky bits")
+		}
+		err := os.Chmod(path, 0750|os.ModeSetuid|os.ModeSticky)
+		assert.NoError(t, err)
+		defer os.Chmod(path, 0640)
+		tag, err := ReadFile(path)
+		assert.NoError(t, err)
+		tag.SetTitle("TestTitle4")
+		err = SaveFile(path, tag, SaveOptions{})
+		assert.NoError(t, err)
+		fi, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, 0750|os.ModeSetuid|os.ModeSticky, fi.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSticky))
+	})
+
+	t.Run("keeps symlinks", func(t *testing.T) {
+		link := "./testdata/temp/link.m4a"
+		err := os.Symlink(filepath.Base(path), link)
+		if err != nil {
+			t.Skip("symlinks not supported:", err)
+		}
+		defer os.Remove(link)
+		tag, err := ReadFile(link)
+		assert.NoError(t, err)
+		tag.SetTitle("TestTitle5")
+		err = SaveFile(link, tag, SaveOptions{})
+		assert.NoError(t, err)
+		fi, err := os.Lstat(link)
+		assert.NoError(t, err)
+		assert.NotZero(t, fi.Mode()&os.ModeSymlink)
+		tag, err = ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, "TestTitle5", tag.GetTitle())
+	})
+}
+
+func TestCoverArtDataM4A(t *testing.T) {
+	b, err := os.ReadFile("./testdata/test1.m4a")
+	assert.NoError(t, err)
+	jpg, err := os.ReadFile("./testdata/testdata-img-1.jpg")
+	assert.NoError(t, err)
+	tag, err := ReadMP4(bytes.NewReader(b))
+	assert.NoError(t, err)
+	art := tag.GetCoverArtData()
+	assert.NotNil(t, art)
+	assert.NotNil(t, tag.GetCoverArt())
+
+	save := func(tag *MP4Tag) *MP4Tag {
+		buffy := new(bytes.Buffer)
+		err := tag.Save(buffy)
+		assert.NoError(t, err)
+		tag, err = ReadMP4(bytes.NewReader(buffy.Bytes()))
+		assert.NoError(t, err)
+		return tag
+	}
+
+	t.Run("unchanged art kept as is", func(t *testing.T) {
+		tag.SetTitle("TestTitle1")
+		saved := save(tag)
+		assert.Equal(t, art, saved.GetCoverArtData())
+	})
+
+	t.Run("bytes kept with their type", func(t *testing.T) {
+		tag.SetCoverArtData(jpg)
+		saved := save(tag)
+		assert.Equal(t, &Artwork{Type: ArtworkTypeJPEG, Data: jpg}, saved.GetCoverArtData())
+	})
+
+	t.Run("image saved as png", func(t *testing.T) {
+		img := *tag.GetCoverArt()
+		tag.SetCoverArt(&img)
+		saved := save(tag)
+		assert.Equal(t, uint32(ArtworkTypePNG), saved.GetCoverArtData().Type)
+		assert.Equal(t, img.Bounds(), (*saved.GetCoverArt()).Bounds())
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		tag.SetCoverArt(nil)
+		saved := save(tag)
+		assert.Nil(t, saved.GetCoverArtData())
+		assert.Nil(t, saved.GetCoverArt())
+	})
+}
+
+func TestArtworksM4A(t *testing.T) {
+	b, err := os.ReadFile("./testdata/test1.m4a")
+	assert.NoError(t, err)
+	jpg, err := os.ReadFile("./testdata/testdata-img-1.jpg")
+	assert.NoError(t, err)
+	tag, err := ReadMP4(bytes.NewReader(b))
+	assert.NoError(t, err)
+	front := *tag.GetCoverArtData()
+	back := NewArtwork(jpg)
+	booklet := Artwork{Type: ArtworkTypeBMP, Data: []byte("BM booklet")}
+
+	save := func(tag *MP4Tag) *MP4Tag {
+		buffy := new(bytes.Buffer)
+		err := tag.Save(buffy)
+		assert.NoError(t, err)
+		tag, err = ReadMP4(bytes.NewReader(buffy.Bytes()))
+		assert.NoError(t, err)
+		return tag
+	}
+
+	t.Run("added", func(t *testing.T) {
+		assert.Equal(t, uint32(ArtworkTypeJPEG), back.Type)
+		tag.AddArtwork(back)
+		tag.AddArtwork(booklet)
+		saved := save(tag)
+		assert.Equal(t, []Artwork{front, back, booklet}, saved.GetArtworks())
+		assert.NotNil(t, saved.GetCoverArt())
+	})
+
+	t.Run("reordered", func(t *testing.T) {
+		tag.MoveArtwork(2, 0)
+		saved := save(tag)
+		assert.Equal(t, []Artwork{booklet, front, back}, saved.GetArtworks())
+		// the booklet is no image the library can decode
+		assert.Nil(t, saved.GetCoverArt())
+		tag.MoveArtwork(0, 2)
+		saved = save(tag)
+		assert.Equal(t, []Artwork{front, back, booklet}, saved.GetArtworks())
+		assert.NotNil(t, saved.GetCoverArt())
+	})
+
+	t.Run("front cover replaced by image", func(t *testing.T) {
+		img := *tag.GetCoverArt()
+		tag.SetCoverArt(&img)
+		saved := save(tag)
+		arts := saved.GetArtworks()
+		assert.Len(t, arts, 3)
+		assert.Equal(t, uint32(ArtworkTypePNG), arts[0].Type)
+		assert.Equal(t, []Artwork{back, booklet}, arts[1:])
+		tag = saved
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		tag.RemoveArtwork(1)
+		tag.RemoveArtwork(5)
+		saved := save(tag)
+		assert.Equal(t, tag.GetArtworks(), saved.GetArtworks())
+		assert.Len(t, saved.GetArtworks(), 2)
+		tag.SetArtworks(nil)
+		saved = save(tag)
+		assert.Empty(t, saved.GetArtworks())
+		assert.Nil(t, saved.GetCoverArt())
+	})
+
+	t.Run("last call wins", func(t *testing.T) {
+		tag, err := ReadMP4(bytes.NewReader(b))
+		assert.NoError(t, err)
+		img := *tag.GetCoverArt()
+		tag.SetCoverArt(&img)
+		tag.SetArtworks([]Artwork{back})
+		saved := save(tag)
+		assert.Equal(t, []Artwork{back}, saved.GetArtworks())
+
+		tag.SetCoverArt(&img)
+		tag.AddArtwork(booklet)
+		saved = save(tag)
+		arts := saved.GetArtworks()
+		assert.Len(t, arts, 2)
+		assert.Equal(t, uint32(ArtworkTypePNG), arts[0].Type)
+		assert.Equal(t, booklet, arts[1])
+
+		tag.SetCoverArt(nil)
+		tag.MoveArtwork(0, 0)
+		saved = save(tag)
+		assert.Equal(t, []Artwork{booklet}, saved.GetArtworks())
+
+		tag.SetCoverArt(&img)
+		tag.RemoveArtwork(1)
+		saved = save(tag)
+		arts = saved.GetArtworks()
+		assert.Len(t, arts, 1)
+		assert.Equal(t, uint32(ArtworkTypePNG), arts[0].Type)
+	})
+}
+
+func TestChaptersM4A(t *testing.T) {
+	src, err := os.ReadFile("./testdata/testdata-m4a-nonEmpty.m4a")
+	assert.NoError(t, err)
+	tag, err := ReadMP4(bytes.NewReader(src))
+	assert.NoError(t, err)
+	assert.Empty(t, tag.GetChapters())
+	chapters := []Chapter{
+		{Start: 0, Title: "Intro"},
+		{Start: 1500 * time.Millisecond, Title: "Kapitel 2 – Über", URL: "https://example.com/2"},
+		{Start: 3 * time.Second, Title: "End"},
+	}
+
+	// the chapter track comes last, so its chunk follows those of the audio
+	assertSameAudioChunks := func(t *testing.T, b []byte) {
+		eOffsets := chunkOffsets(t, src)
+		aOffsets := chunkOffsets(t, b)
+		assert.GreaterOrEqual(t, len(aOffsets), len(eOffsets))
+		for i := range eOffsets {
+			assert.Equal(t, src[eOffsets[i]:eOffsets[i]+16], b[aOffsets[i]:aOffsets[i]+16])
+		}
+	}
+	save := func(b []byte, tag *MP4Tag) []byte {
+		buffy := new(bytes.Buffer)
+		err := SaveMP4(bytes.NewReader(b), buffy, tag)
+		assert.NoError(t, err)
+		return buffy.Bytes()
+	}
+	mdats := func(b []byte) int {
+		bis, err := mp4lib.ExtractBoxes(bytes.NewReader(b), nil, []mp4lib.BoxPath{{mp4lib.BoxTypeMdat()}})
+		assert.NoError(t, err)
+		return len(bis)
+	}
+	var b []byte
+
+	t.Run("written", func(t *testing.T) {
+		tag.SetChapters(chapters)
+		b = save(src, tag)
+		saved, err := ReadMP4(bytes.NewReader(b))
+		assert.NoError(t, err)
+		assert.Equal(t, chapters, saved.GetChapters())
+		assertSameAudioChunks(t, b)
+
+		// centered, black on white
+		entry := make([]byte, 43)
+		binary.BigEndian.PutUint32(entry[4:], 1)
+		copy(entry[8:], bytes.Repeat([]byte{0xFF}, 6))
+		assert.True(t, bytes.Contains(b, boxBytes("text", []byte{0, 0, 0, 0, 0, 0, 0, 1}, entry)))
+
+		movie, err := readMovie(bytes.NewReader(b))
+		assert.NoError(t, err)
+		assert.Len(t, movie.tracks, 2)
+		assert.Equal(t, []uint32{2}, movie.tracks[0].chapterIDs)
+		assert.Equal(t, uint32(3), movie.nextTrackID)
+		// Nero chapters carry no URL
+		nero := append([]Chapter(nil), chapters...)
+		nero[1].URL = ""
+		assert.Equal(t, nero, movie.chpl)
+	})
+
+	t.Run("replaced", func(t *testing.T) {
+		tag, err := ReadMP4(bytes.NewReader(b))
+		assert.NoError(t, err)
+		tag.SetChapters([]Chapter{{Start: 0, Title: "Only"}})
+		tag.SetTitle("TestTitle1")
+		b = save(b, tag)
+		saved, err := ReadMP4(bytes.NewReader(b))
+		assert.NoError(t, err)
+		assert.Equal(t, []Chapter{{Start: 0, Title: "Only"}}, saved.GetChapters())
+		assert.Equal(t, "TestTitle1", saved.GetTitle())
+		assertSameAudioChunks(t, b)
+
+		movie, err := readMovie(bytes.NewReader(b))
+		assert.NoError(t, err)
+		assert.Len(t, movie.tracks, 2)
+		// the mdat of the old chapters is left out
+		assert.Equal(t, 2, mdats(b))
+	})
+
+	t.Run("replaced again keeps the size", func(t *testing.T) {
+		tag, err := ReadMP4(bytes.NewReader(b))
+		assert.NoError(t, err)
+		tag.SetChapters([]Chapter{{Start: 0, Title: "Only"}})
+		saved := save(b, tag)
+		assert.Equal(t, len(b), len(saved))
+		assertSameChunks(t, b, saved)
+	})
+
+	t.Run("kept when unchanged", func(t *testing.T) {
+		tag, err := ReadMP4(bytes.NewReader(b))
+		assert.NoError(t, err)
+		tag.SetArtist("TestArtist1")
+		b = save(b, tag)
+		saved, err := ReadMP4(bytes.NewReader(b))
+		assert.NoError(t, err)
+		assert.Equal(t, []Chapter{{Start: 0, Title: "Only"}}, saved.GetChapters())
+	})
+
+	t.Run("kept with all tags cleared", func(t *testing.T) {
+		tag, err := ReadMP4(bytes.NewReader(b))
+		assert.NoError(t, err)
+		chapters := tag.GetChapters()
+		assert.NotEmpty(t, chapters)
+		tag.ClearAllTags()
+		assert.Equal(t, chapters, tag.GetChapters())
+		saved, err := ReadMP4(bytes.NewReader(save(b, tag)))
+		assert.NoError(t, err)
+		assert.Equal(t, chapters, saved.GetChapters())
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		tag, err := ReadMP4(bytes.NewReader(b))
+		assert.NoError(t, err)
+		tag.SetChapters(nil)
+		b = save(b, tag)
+		saved, err := ReadMP4(bytes.NewReader(b))
+		assert.NoError(t, err)
+		assert.Empty(t, saved.GetChapters())
+		assertSameChunks(t, src, b)
+		assert.Equal(t, 1, mdats(b))
+
+		movie, err := readMovie(bytes.NewReader(b))
+		assert.NoError(t, err)
+		assert.Len(t, movie.tracks, 1)
+		assert.Empty(t, movie.tracks[0].chapterIDs)
+		assert.Empty(t, movie.chpl)
+	})
+}
+
+func TestLyricsM4A(t *testing.T) {
+	src, err := os.ReadFile("./testdata/testdata-m4a-nonEmpty.m4a")
+	assert.NoError(t, err)
+	save := func(b []byte, tag *MP4Tag) []byte {
+		buffy := new(bytes.Buffer)
+		err := SaveMP4(bytes.NewReader(b), buffy, tag)
+		assert.NoError(t, err)
+		return buffy.Bytes()
+	}
+	read := func(b []byte) *MP4Tag {
+		tag, err := ReadMP4(bytes.NewReader(b))
+		assert.NoError(t, err)
+		return tag
+	}
+
+	t.Run("unsynced", func(t *testing.T) {
+		tag := read(src)
+		tag.SetLyrics("Line one\nLine two")
+		saved := read(save(src, tag))
+		assert.Equal(t, "Line one\nLine two", saved.GetLyrics())
+		saved.ClearAllTags()
+		assert.Empty(t, saved.GetLyrics())
+	})
+
+	t.Run("parse lrc", func(t *testing.T) {
+		lrc := "\ufeff[ar:Artist]\n[ti:Title]\n[offset:+100]\n" +
+			"[00:01.50]Second\n[00:00.6][00:02.345]Chorus\n" +
+			"no timestamp\n[01:00:10] Late \n"
+		lines, err := ParseLRC(strings.NewReader(lrc))
+		assert.NoError(t, err)
+		assert.Equal(t, []LyricLine{
+			{Start: 500 * time.Millisecond, Text: "Chorus"},
+			{Start: 1400 * time.Millisecond, Text: "Second"},
+			{Start: 2245 * time.Millisecond, Text: "Chorus"},
+			{Start: time.Minute, Text: "Late"},
+		}, lines)
+		formatted := FormatLRC(lines)
+		assert.Equal(t, "[00:00.50]Chorus\n[00:01.40]Second\n[00:02.24]Chorus\n[01:00.00]Late\n", formatted)
+		again, err := ParseLRC(strings.NewReader(formatted))
+		assert.NoError(t, err)
+		assert.Equal(t, lines[:2], again[:2])
+	})
+
+	lines := []LyricLine{
+		{Start: 500 * time.Millisecond, Text: "Hello"},
+		{Start: 1500 * time.Millisecond, Text: ""},
+		{Start: 2 * time.Second, Text: "World – Ünïcode"},
+	}
+	chapters := []Chapter{{Start: 0, Title: "One"}, {Start: 2 * time.Second, Title: "Two"}}
+	var b []byte
+
+	t.Run("synced with chapters", func(t *testing.T) {
+		tag := read(src)
+		tag.SetSyncedLyrics(lines)
+		tag.SetChapters(chapters)
+		b = save(src, tag)
+		saved := read(b)
+		assert.Equal(t, lines, saved.GetSyncedLyrics())
+		assert.Equal(t, chapters, saved.GetChapters())
+		movie, err := readMovie(bytes.NewReader(b))
+		assert.NoError(t, err)
+		assert.Len(t, movie.tracks, 3)
+	})
+
+	t.Run("synced replaced", func(t *testing.T) {
+		tag := read(b)
+		tag.SetSyncedLyrics(lines[:1])
+		b = save(b, tag)
+		saved := read(b)
+		assert.Equal(t, lines[:1], saved.GetSyncedLyrics())
+		assert.Equal(t, chapters, saved.GetChapters())
+		movie, err := readMovie(bytes.NewReader(b))
+		assert.NoError(t, err)
+		assert.Len(t, movie.tracks, 3)
+		// the old lyrics samples are dropped, the chapters keep theirs
+		bis, err := mp4lib.ExtractBoxes(bytes.NewReader(b), nil, []mp4lib.BoxPath{{mp4lib.BoxTypeMdat()}})
+		assert.NoError(t, err)
+		assert.Len(t, bis, 3)
+	})
+
+	t.Run("synced kept with all tags cleared", func(t *testing.T) {
+		tag := read(b)
+		lines := tag.GetSyncedLyrics()
+		assert.NotEmpty(t, lines)
+		tag.ClearAllTags()
+		assert.Equal(t, lines, tag.GetSyncedLyrics())
+		saved := read(save(b, tag))
+		assert.Equal(t, lines, saved.GetSyncedLyrics())
+		assert.NotEmpty(t, saved.GetChapters())
+	})
+
+	t.Run("synced with shared timestamps", func(t *testing.T) {
+		tag := read(src)
+		tag.SetSyncedLyrics([]LyricLine{
+			{Start: time.Second, Text: "Lead"},
+			{Start: 2 * time.Second, Text: "Last"},
+			{Start: time.Second, Text: "Backing"},
+		})
+		saved := read(save(src, tag))
+		// the line after them keeps its start
+		assert.Equal(t, []LyricLine{
+			{Start: time.Second, Text: "Lead\nBacking"},
+			{Start: 2 * time.Second, Text: "Last"},
+		}, saved.GetSyncedLyrics())
+	})
+
+	t.Run("synced removed", func(t *testing.T) {
+		tag := read(b)
+		tag.SetSyncedLyrics(nil)
+		tag.SetChapters(nil)
+		b = save(b, tag)
+		saved := read(b)
+		assert.Empty(t, saved.GetSyncedLyrics())
+		assert.Empty(t, saved.GetChapters())
+		assertSameChunks(t, src, b)
+	})
+}
+
+func TestSortTagsM4A(t *testing.T) {
+	b, err := os.ReadFile("./testdata/testdata-m4a-nonEmpty.m4a")
+	assert.NoError(t, err)
+	tag, err := ReadMP4(bytes.NewReader(b))
+	assert.NoError(t, err)
+	tag.SetSortTitle("Yesterday")
+	tag.SetSortArtist("Beatles, The")
+	tag.SetSortAlbumArtist("Beatles, The")
+	tag.SetSortAlbum("Help")
+	tag.SetSortComposer("Lennon, John")
+	tag.SetSortShow("きょうのニュース")
+	buffy := new(bytes.Buffer)
+	err = tag.Save(buffy)
+	assert.NoError(t, err)
+
+	saved, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "Yesterday", saved.GetSortTitle())
+	assert.Equal(t, "Beatles, The", saved.GetSortArtist())
+	assert.Equal(t, "Beatles, The", saved.GetSortAlbumArtist())
+	assert.Equal(t, "Help", saved.GetSortAlbum())
+	assert.Equal(t, "Lennon, John", saved.GetSortComposer())
+	assert.Equal(t, "きょうのニュース", saved.GetSortShow())
+	for _, atom := range saved.GetUnknownAtoms() {
+		assert.NotEqual(t, "so", string(atom.Type[:2]))
+	}
+
+	saved.ClearAllTags()
+	buffy = new(bytes.Buffer)
+	err = saved.Save(buffy)
+	assert.NoError(t, err)
+	cleared, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+	assert.NoError(t, err)
+	assert.Empty(t, cleared.GetSortTitle())
+	assert.Empty(t, cleared.GetSortArtist())
+	assert.Empty(t, cleared.GetSortAlbumArtist())
+	assert.Empty(t, cleared.GetSortAlbum())
+	assert.Empty(t, cleared.GetSortComposer())
+	assert.Empty(t, cleared.GetSortShow())
+}
+
+func TestClassicalM4A(t *testing.T) {
+	b, err := os.ReadFile("./testdata/testdata-m4a-nonEmpty.m4a")
+	assert.NoError(t, err)
+	tag, err := ReadMP4(bytes.NewReader(b))
+	assert.NoError(t, err)
+	tag.SetGrouping("Symphonies")
+	tag.SetWork("Symphony No. 9 in D minor, Op. 125")
+	tag.SetMovementName("Presto – Allegro assai")
+	tag.SetMovementNumber(4)
+	tag.SetMovementCount(4)
+	tag.SetShowWorkMovement(true)
+	buffy := new(bytes.Buffer)
+	err = tag.Save(buffy)
+	assert.NoError(t, err)
+
+	saved, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "Symphonies", saved.GetGrouping())
+	assert.Equal(t, "Symphony No. 9 in D minor, Op. 125", saved.GetWork())
+	assert.Equal(t, "Presto – Allegro assai", saved.GetMovementName())
+	assert.Equal(t, 4, saved.GetMovementNumber())
+	assert.Equal(t, 4, saved.GetMovementCount())
+	assert.True(t, saved.GetShowWorkMovement())
+
+	t.Run("integer sizes", func(t *testing.T) {
+		items := ilstItems(t, buffy.Bytes())
+		assert.Len(t, items[mp4lib.BoxType{'\251', 'm', 'v', 'i'}].Data, 2)
+		assert.Len(t, items[mp4lib.BoxType{'\251', 'm', 'v', 'c'}].Data, 2)
+		assert.Len(t, items[mp4lib.BoxType{'s', 'h', 'w', 'm'}].Data, 1)
+	})
+
+	t.Run("flag cleared", func(t *testing.T) {
+		saved.SetShowWorkMovement(false)
+		buffy := new(bytes.Buffer)
+		err := saved.Save(buffy)
+		assert.NoError(t, err)
+		cleared, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+		assert.NoError(t, err)
+		assert.False(t, cleared.GetShowWorkMovement())
+		assert.Equal(t, 4, cleared.GetMovementNumber())
+	})
+}
+
+func TestFlagsM4A(t *testing.T) {
+	b, err := os.ReadFile("./testdata/test1.m4a")
+	assert.NoError(t, err)
+	tag, err := ReadMP4(bytes.NewReader(b))
+	assert.NoError(t, err)
+	// the file stores cpil and pgap as 0
+	assert.False(t, tag.GetCompilation())
+	assert.False(t, tag.GetGaplessPlayback())
+	for _, atom := range tag.GetUnknownAtoms() {
+		assert.NotContains(t, []string{"cpil", "pgap", "stik", "rtng"}, string(atom.Type[:]))
+	}
+
+	tag.SetCompilation(true)
+	tag.SetGaplessPlayback(true)
+	tag.SetMediaKind(MediaKindAudiobook)
+	tag.SetContentRating(ContentRatingExplicit)
+	buffy := new(bytes.Buffer)
+	err = tag.Save(buffy)
+	assert.NoError(t, err)
+
+	saved, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+	assert.NoError(t, err)
+	assert.True(t, saved.GetCompilation())
+	assert.True(t, saved.GetGaplessPlayback())
+	assert.Equal(t, MediaKindAudiobook, saved.GetMediaKind())
+	assert.Equal(t, ContentRatingExplicit, saved.GetContentRating())
+
+	t.Run("1-byte integers", func(t *testing.T) {
+		items := ilstItems(t, buffy.Bytes())
+		for _, typ := range []string{"cpil", "pgap", "stik", "rtng"} {
+			item := items[mp4lib.StrToBoxType(typ)]
+			assert.Equal(t, uint32(mp4lib.DataTypeSignedIntBigEndian), item.DataType, typ)
+			assert.Len(t, item.Data, 1, typ)
+		}
+		assert.Equal(t, []byte{2}, items[mp4lib.StrToBoxType("stik")].Data)
+	})
+
+	t.Run("cleared", func(t *testing.T) {
+		saved.ClearAllTags()
+		buffy := new(bytes.Buffer)
+		err := saved.Save(buffy)
+		assert.NoError(t, err)
+		cleared, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+		assert.NoError(t, err)
+		assert.False(t, cleared.GetCompilation())
+		assert.False(t, cleared.GetGaplessPlayback())
+		assert.Zero(t, cleared.GetMediaKind())
+		assert.Zero(t, cleared.GetContentRating())
+	})
+}
+
+func TestPodcastM4A(t *testing.T) {
+	b, err := os.ReadFile("./testdata/test1.m4a")
+	assert.NoError(t, err)
+	tag, err := ReadMP4(bytes.NewReader(b))
+	assert.NoError(t, err)
+	long := strings.Repeat("In this episode we talk about MP4 metadata. ", 20)
+	tag.SetPodcast(true)
+	tag.SetPodcastURL("https://example.com/feed.xml")
+	tag.SetEpisodeGUID("urn:uuid:3f1c2b9e-0d4a-4c55-9a0e-7e1b2c3d4e5f")
+	tag.SetCategory("Technology")
+	tag.SetKeywords("mp4,metadata,go")
+	tag.SetDescription("Tags, atoms and boxes")
+	tag.SetLongDescription(long)
+	tag.SetMediaKind(MediaKindPodcast)
+	buffy := new(bytes.Buffer)
+	err = tag.Save(buffy)
+	assert.NoError(t, err)
+
+	saved, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+	assert.NoError(t, err)
+	assert.True(t, saved.GetPodcast())
+	assert.Equal(t, "https://example.com/feed.xml", saved.GetPodcastURL())
+	assert.Equal(t, "urn:uuid:3f1c2b9e-0d4a-4c55-9a0e-7e1b2c3d4e5f", saved.GetEpisodeGUID())
+	assert.Equal(t, "Technology", saved.GetCategory())
+	assert.Equal(t, "mp4,metadata,go", saved.GetKeywords())
+	assert.Equal(t, "Tags, atoms and boxes", saved.GetDescription())
+	assert.Equal(t, long, saved.GetLongDescription())
+	assert.Equal(t, MediaKindPodcast, saved.GetMediaKind())
+	assert.Empty(t, saved.GetUnknownAtoms())
+
+	t.Run("data types", func(t *testing.T) {
+		items := ilstItems(t, buffy.Bytes())
+		assert.Equal(t, uint32(mp4lib.DataTypeBinary), items[mp4lib.StrToBoxType("purl")].DataType)
+		assert.Equal(t, uint32(mp4lib.DataTypeBinary), items[mp4lib.StrToBoxType("egid")].DataType)
+		assert.Equal(t, uint32(mp4lib.DataTypeStringUTF8), items[mp4lib.StrToBoxType("ldes")].DataType)
+		assert.Equal(t, []byte{1}, items[mp4lib.StrToBoxType("pcst")].Data)
+	})
+}
+
+func TestTVShowM4V(t *testing.T) {
+	src := videoFile(t)
+	tag, err := ReadMP4(bytes.NewReader(src))
+	assert.NoError(t, err)
+	assert.Empty(t, tag.GetTVShow())
+	tag.SetTitle("Pilot")
+	tag.SetTVShow("The Show")
+	tag.SetSortShow("Show, The")
+	tag.SetTVEpisodeID("S01E01")
+	tag.SetTVSeason(1)
+	tag.SetTVEpisode(70000)
+	tag.SetTVNetwork("Network")
+	tag.SetHDVideo(HDVideo1080p)
+	tag.SetMediaKind(MediaKindTVShow)
+	buffy := new(bytes.Buffer)
+	err = tag.Save(buffy)
+	assert.NoError(t, err)
+	assertSameChunks(t, src, buffy.Bytes())
+
+	saved, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "Pilot", saved.GetTitle())
+	assert.Equal(t, "The Show", saved.GetTVShow())
+	assert.Equal(t, "Show, The", saved.GetSortShow())
+	assert.Equal(t, "S01E01", saved.GetTVEpisodeID())
+	assert.Equal(t, 1, saved.GetTVSeason())
+	assert.Equal(t, 70000, saved.GetTVEpisode())
+	assert.Equal(t, "Network", saved.GetTVNetwork())
+	assert.Equal(t, HDVideo1080p, saved.GetHDVideo())
+	assert.Equal(t, MediaKindTVShow, saved.GetMediaKind())
+
+	t.Run("integer sizes", func(t *testing.T) {
+		items := ilstItems(t, buffy.Bytes())
+		assert.Len(t, items[mp4lib.StrToBoxType("tvsn")].Data, 4)
+		assert.Len(t, items[mp4lib.StrToBoxType("tves")].Data, 4)
+		assert.Len(t, items[mp4lib.StrToBoxType("hdvd")].Data, 1)
+	})
+
+	t.Run("video track kept", func(t *testing.T) {
+		bis, err := mp4lib.ExtractBox(bytes.NewReader(buffy.Bytes()), nil, mp4lib.BoxPath{mp4lib.BoxTypeMoov(), mp4lib.BoxTypeTrak(), mp4lib.BoxTypeMdia(), mp4lib.BoxTypeMinf(), mp4lib.BoxTypeStbl(), mp4lib.BoxTypeStsd(), mp4lib.BoxTypeAvc1()})
+		assert.NoError(t, err)
+		assert.Len(t, bis, 1)
+	})
+}
+
+func TestStoreInfoM4A(t *testing.T) {
+	b, err := os.ReadFile("./testdata/test1.m4a")
+	assert.NoError(t, err)
+	tag, err := ReadMP4(bytes.NewReader(b))
+	assert.NoError(t, err)
+	tag.SetContentID(3000000000)
+	tag.SetArtistID(136975)
+	tag.SetPlaylistID(1<<40 + 5)
+	tag.SetGenreID(21)
+	tag.SetStorefrontID(143441)
+	tag.SetAccountKind(1)
+	tag.SetComposerID(4242)
+	tag.SetPurchaseDate("2021-05-04 10:11:12")
+	tag.SetAppleID("someone@example.com")
+	tag.SetOwner("Someone")
+	buffy := new(bytes.Buffer)
+	err = tag.Save(buffy)
+	assert.NoError(t, err)
+
+	saved, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, 3000000000, saved.GetContentID())
+	assert.Equal(t, 136975, saved.GetArtistID())
+	assert.Equal(t, int64(1<<40+5), saved.GetPlaylistID())
+	assert.Equal(t, 21, saved.GetGenreID())
+	assert.Equal(t, 143441, saved.GetStorefrontID())
+	assert.Equal(t, 1, saved.GetAccountKind())
+	assert.Equal(t, 4242, saved.GetComposerID())
+	assert.Equal(t, "2021-05-04 10:11:12", saved.GetPurchaseDate())
+	assert.Equal(t, "someone@example.com", saved.GetAppleID())
+	assert.Equal(t, "Someone", saved.GetOwner())
+
+	t.Run("integer sizes", func(t *testing.T) {
+		items := ilstItems(t, buffy.Bytes())
+		for typ, size := range map[string]int{"cnID": 4, "atID": 4, "plID": 8, "geID": 4, "sfID": 4, "akID": 1, "cmID": 4} {
+			assert.Len(t, items[mp4lib.StrToBoxType(typ)].Data, size, typ)
+		}
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		saved.SetAppleID("")
+		saved.SetOwner("")
+		saved.SetPlaylistID(0)
+		buffy := new(bytes.Buffer)
+		err := saved.Save(buffy)
+		assert.NoError(t, err)
+		items := ilstItems(t, buffy.Bytes())
+		assert.NotContains(t, items, mp4lib.StrToBoxType("apID"))
+		assert.NotContains(t, items, mp4lib.StrToBoxType("ownr"))
+		assert.NotContains(t, items, mp4lib.StrToBoxType("plID"))
+		assert.Contains(t, items, mp4lib.StrToBoxType("cnID"))
+	})
+}
+
+func TestReleaseDateM4A(t *testing.T) {
+	t.Run("parse", func(t *testing.T) {
+		for _, tt := range []struct {
+			in        string
+			out       string
+			precision DatePrecision
+		}{
+			{"2019", "2019", DatePrecisionYear},
+			{"2019-05", "2019-05", DatePrecisionMonth},
+			{"2019-05-17", "2019-05-17", DatePrecisionDay},
+			{"2019-05-17T07:00:00Z", "2019-05-17T07:00:00Z", DatePrecisionTime},
+			{"2019-05-17T07:00:00.000Z", "2019-05-17T07:00:00Z", DatePrecisionTime},
+			{"2019-05-17T09:00:00+02:00", "2019-05-17T09:00:00+02:00", DatePrecisionTime},
+			{"2019-05-17T07:00:00+0000", "2019-05-17T07:00:00Z", DatePrecisionTime},
+			{"2019-05-17T07:00:00", "2019-05-17T07:00:00Z", DatePrecisionTime},
+			{"2019-05-17 07:00:00", "2019-05-17T07:00:00Z", DatePrecisionTime},
+			{"2019-05-17T07:00:00.25Z", "2019-05-17T07:00:00.25Z", DatePrecisionTime},
+			{"2019-05-17T07:00:00.125+02:00", "2019-05-17T07:00:00.125+02:00", DatePrecisionTime},
+			{" 2019-05-17T07:00Z ", "2019-05-17T07:00Z", DatePrecisionMinute},
+			{"2019-05-17T09:00+02:00", "2019-05-17T09:00+02:00", DatePrecisionMinute},
+			{"2019-05-17T07:00", "2019-05-17T07:00Z", DatePrecisionMinute},
+		} {
+			d, err := ParseReleaseDate(tt.in)
+			assert.NoError(t, err, tt.in)
+			assert.Equal(t, tt.precision, d.Precision, tt.in)
+			assert.Equal(t, tt.out, d.String(), tt.in)
+		}
+		_, err := ParseReleaseDate("May 2019")
+		assert.Error(t, err)
+	})
+
+	b, err := os.ReadFile("./testdata/test1.m4a")
+	assert.NoError(t, err)
+	tag, err := ReadMP4(bytes.NewReader(b))
+	assert.NoError(t, err)
+	d, err := ParseReleaseDate("2019-05-17T07:00:00Z")
+	assert.NoError(t, err)
+	tag.SetReleaseDate(d)
+	tag.SetFreeform(FreeformMeanITunes, "ORIGINALDATE", "1969")
+	original, err := tag.GetOriginalReleaseDate()
+	assert.NoError(t, err)
+	assert.Equal(t, DatePrecisionYear, original.Precision)
+	original, err = ParseReleaseDate("1969-09-26")
+	assert.NoError(t, err)
+	tag.SetOriginalReleaseDate(original)
+	buffy := new(bytes.Buffer)
+	err = tag.Save(buffy)
+	assert.NoError(t, err)
+
+	saved, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "2019-05-17T07:00:00Z", saved.Year)
+	assert.Equal(t, 2019, saved.GetYear())
+	date, err := saved.GetReleaseDate()
+	assert.NoError(t, err)
+	assert.Equal(t, DatePrecisionTime, date.Precision)
+	assert.True(t, d.Time.Equal(date.Time))
+	original, err = saved.GetOriginalReleaseDate()
+	assert.NoError(t, err)
+	assert.Equal(t, "1969-09-26", original.String())
+	assert.Empty(t, saved.GetFreeform(FreeformMeanITunes, "ORIGINALDATE"))
+
+	t.Run("set year keeps the date", func(t *testing.T) {
+		saved.SetYear(2020)
+		assert.Equal(t, "2020-05-17T07:00:00Z", saved.Year)
+		saved.SetReleaseDate(ReleaseDate{})
+		saved.SetYear(2021)
+		assert.Equal(t, "2021", saved.Year)
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		saved.SetReleaseDate(ReleaseDate{})
+		saved.SetOriginalReleaseDate(ReleaseDate{})
+		date, err := saved.GetReleaseDate()
+		assert.NoError(t, err)
+		assert.True(t, date.IsZero())
+		original, err := saved.GetOriginalReleaseDate()
+		assert.NoError(t, err)
+		assert.True(t, original.IsZero())
+	})
+}
+
+func TestMultiValueM4A(t *testing.T) {
+	b, err := os.ReadFile("./testdata/test1.m4a")
+	assert.NoError(t, err)
+	tag, err := ReadMP4(bytes.NewReader(b))
+	assert.NoError(t, err)
+	artistData := append(append(mp4lib.BoxPath{}, ilstPath...), mp4lib.BoxType{'\251', 'A', 'R', 'T'}, mp4lib.BoxTypeData())
+	tag.SetArtists([]string{"Simon", "Garfunkel"})
+	tag.SetComposers([]string{"Paul Simon"})
+	tag.SetGenres([]string{"Folk", "Rock"})
+	assert.Equal(t, "Simon; Garfunkel", tag.GetArtist())
+	buffy := new(bytes.Buffer)
+	err = tag.Save(buffy)
+	assert.NoError(t, err)
+	bis, err := mp4lib.ExtractBox(bytes.NewReader(buffy.Bytes()), nil, artistData)
+	assert.NoError(t, err)
+	assert.Len(t, bis, 2)
+
+	saved, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Simon", "Garfunkel"}, saved.GetArtists())
+	assert.Equal(t, "Simon; Garfunkel", saved.GetArtist())
+	assert.Equal(t, []string{"Paul Simon"}, saved.GetComposers())
+	assert.Equal(t, []string{"Folk", "Rock"}, saved.GetGenres())
+
+	t.Run("joined", func(t *testing.T) {
+		buffy := new(bytes.Buffer)
+		err := saved.SaveWithOptions(buffy, SaveOptions{JoinMultiValues: " / "})
+		assert.NoError(t, err)
+		bis, err := mp4lib.ExtractBox(bytes.NewReader(buffy.Bytes()), nil, artistData)
+		assert.NoError(t, err)
+		assert.Len(t, bis, 1)
+		joined, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Simon / Garfunkel"}, joined.GetArtists())
+		assert.Equal(t, "Folk / Rock", joined.GetGenre())
+	})
+
+	t.Run("replaced by a single value", func(t *testing.T) {
+		saved.SetArtist("Simon & Garfunkel")
+		assert.Equal(t, []string{"Simon & Garfunkel"}, saved.GetArtists())
+		buffy := new(bytes.Buffer)
+		err := saved.Save(buffy)
+		assert.NoError(t, err)
+		bis, err := mp4lib.ExtractBox(bytes.NewReader(buffy.Bytes()), nil, artistData)
+		assert.NoError(t, err)
+		assert.Len(t, bis, 1)
+		single, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+		assert.NoError(t, err)
+		assert.Equal(t, "Simon & Garfunkel", single.GetArtist())
+		assert.Equal(t, []string{"Folk", "Rock"}, single.GetGenres())
+	})
+}
+
+func TestTextValuesM4A(t *testing.T) {
+	b, err := os.ReadFile("./testdata/test1.m4a")
+	assert.NoError(t, err)
+	tag, err := ReadMP4(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Equal(t, "deu", TextValue{Language: PackLanguage("deu")}.LanguageCode())
+	assert.Empty(t, TextValue{Language: 3}.LanguageCode())
+	assert.Error(t, tag.SetTextValues("BPM", nil))
+
+	titles := []TextValue{
+		{Text: "Der Titel", DataType: mp4lib.DataTypeStringUTF16, Country: 276, Language: PackLanguage("deu")},
+		{Text: "The Title", DataType: mp4lib.DataTypeStringUTF8, Language: PackLanguage("eng")},
+	}
+	assert.NoError(t, tag.SetTextValues("Title", titles))
+	assert.Equal(t, "Der Titel", tag.GetTitle())
+	tag.SetFreeformData(Freeform{Mean: FreeformMeanITunes, Name: "UTF16", Values: []FreeformValue{{DataType: mp4lib.DataTypeStringUTF16, Data: []byte{0, 'o', 0, 'k'}}}})
+	buffy := new(bytes.Buffer)
+	err = tag.Save(buffy)
+	assert.NoError(t, err)
+
+	bis, err := mp4lib.ExtractBox(bytes.NewReader(buffy.Bytes()), nil, append(append(mp4lib.BoxPath{}, ilstPath...), mp4lib.BoxType{'\251', 'n', 'a', 'm'}))
+	assert.NoError(t, err)
+	if assert.Len(t, bis, 1) {
+		datas := parseDataBoxes(buffy.Bytes()[bis[0].Offset+bis[0].HeaderSize : bis[0].Offset+bis[0].Size])
+		assert.Len(t, datas, 2)
+		assert.Equal(t, uint32(mp4lib.DataTypeStringUTF16), datas[0].DataType)
+		assert.Equal(t, uint32(276)<<16|uint32(PackLanguage("deu")), datas[0].DataLang)
+		assert.Equal(t, []byte{0, 'D', 0, 'e', 0, 'r'}, datas[0].Data[:6])
+	}
+
+	saved, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "Der Titel", saved.GetTitle())
+	assert.Equal(t, titles, saved.GetTextValues("Title"))
+	assert.Equal(t, "ok", saved.GetFreeform(FreeformMeanITunes, "UTF16"))
+
+	t.Run("changed value keeps the type", func(t *testing.T) {
+		saved.SetTitle("Ein Titel")
+		assert.Equal(t, []TextValue{{Text: "Ein Titel", DataType: mp4lib.DataTypeStringUTF16, Country: 276, Language: PackLanguage("deu")}}, saved.GetTextValues("Title"))
+		buffy := new(bytes.Buffer)
+		err := saved.Save(buffy)
+		assert.NoError(t, err)
+		changed, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+		assert.NoError(t, err)
+		assert.Equal(t, saved.GetTextValues("Title"), changed.GetTextValues("Title"))
+	})
+
+	t.Run("text as UTF-8", func(t *testing.T) {
+		buffy := new(bytes.Buffer)
+		err := saved.SaveWithOptions(buffy, SaveOptions{TextAsUTF8: true})
+		assert.NoError(t, err)
+		plain, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+		assert.NoError(t, err)
+		assert.Equal(t, []TextValue{{Text: saved.GetTitle(), DataType: mp4lib.DataTypeStringUTF8}}, plain.GetTextValues("Title"))
+	})
+
+	t.Run("new values are UTF-8", func(t *testing.T) {
+		assert.Equal(t, []TextValue{{Text: saved.GetAlbum(), DataType: mp4lib.DataTypeStringUTF8}}, saved.GetTextValues("Album"))
+		saved.SetComments("new")
+		assert.Equal(t, []TextValue{{Text: "new", DataType: mp4lib.DataTypeStringUTF8}}, saved.GetTextValues("Comments"))
+	})
+}
+
+func TestQuickTimeTextM4V(t *testing.T) {
+	text := func(language uint16, text string) []byte {
+		payload := []byte{0, byte(len(text)), byte(language >> 8), byte(language)}
+		return append(payload, text...)
+	}
+	udta := boxBytes("udta", bytes.Join([][]byte{
+		// Mac OS Roman "Café"
+		boxBytes("\251nam", text(0, "Caf\x8e")),
+		boxBytes("\251ART", text(PackLanguage("deu"), "Kameramann Müller")),
+		boxBytes("\251day", text(0, "2020-01-02")),
+		boxBytes("\251mak", text(0, "Camera Maker")),
+	}, nil))
+	src := videoFile(t, udta)
+	udtaText := mp4lib.BoxPath{mp4lib.BoxTypeMoov(), mp4lib.BoxTypeUdta(), {'\251', 'n', 'a', 'm'}}
+
+	tag, err := ReadMP4(bytes.NewReader(src))
+	assert.NoError(t, err)
+	assert.Equal(t, "Café", tag.GetTitle())
+	assert.Equal(t, "Kameramann Müller", tag.GetArtist())
+	assert.Equal(t, 2020, tag.GetYear())
+
+	t.Run("changed atoms rewritten", func(t *testing.T) {
+		tag.SetTitle("Holiday")
+		buffy := new(bytes.Buffer)
+		err := tag.Save(buffy)
+		assert.NoError(t, err)
+		assertSameChunks(t, src, buffy.Bytes())
+		saved, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+		assert.NoError(t, err)
+		assert.Equal(t, "Holiday", saved.GetTitle())
+		assert.Equal(t, "Kameramann Müller", saved.GetArtist())
+		bis, err := mp4lib.ExtractBox(bytes.NewReader(buffy.Bytes()), nil, udtaText)
+		assert.NoError(t, err)
+		if assert.Len(t, bis, 1) {
+			assert.Equal(t, "Holiday", parseQuickTimeText(buffy.Bytes()[bis[0].Offset+bis[0].HeaderSize:bis[0].Offset+bis[0].Size]))
+		}
+		// the unchanged ones are kept as they are
+		assert.True(t, bytes.Contains(buffy.Bytes(), boxBytes("\251ART", text(PackLanguage("deu"), "Kameramann Müller"))))
+		// the ilst goes into the udta that is there
+		bis, err = mp4lib.ExtractBox(bytes.NewReader(buffy.Bytes()), nil, mp4lib.BoxPath{mp4lib.BoxTypeMoov(), mp4lib.BoxTypeUdta()})
+		assert.NoError(t, err)
+		assert.Len(t, bis, 1)
+		bis, err = mp4lib.ExtractBox(bytes.NewReader(buffy.Bytes()), nil, mp4lib.BoxPath{mp4lib.BoxTypeMoov(), mp4lib.BoxTypeUdta(), mp4lib.BoxTypeMeta(), mp4lib.BoxTypeIlst()})
+		assert.NoError(t, err)
+		assert.Len(t, bis, 1)
+	})
+
+	t.Run("cleared atoms removed", func(t *testing.T) {
+		tag, err := ReadMP4(bytes.NewReader(src))
+		assert.NoError(t, err)
+		tag.SetTitle("")
+		tag.SetArtist("")
+		// udta changes, so it can't be saved in place
+		f := &memFile{b: src}
+		ok, err := saveInPlace(f, f, tag, &SaveOptions{InPlace: true})
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		buffy := new(bytes.Buffer)
+		err = tag.Save(buffy)
+		assert.NoError(t, err)
+		saved, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+		assert.NoError(t, err)
+		assert.Empty(t, saved.GetTitle())
+		assert.Empty(t, saved.GetArtist())
+		assert.Equal(t, "2020-01-02", saved.Year)
+		bis, err := mp4lib.ExtractBox(bytes.NewReader(buffy.Bytes()), nil, udtaText)
+		assert.NoError(t, err)
+		assert.Empty(t, bis)
+	})
+
+	t.Run("written", func(t *testing.T) {
+		tag.SetTitle("Holiday")
+		buffy := new(bytes.Buffer)
+		err := tag.SaveWithOptions(buffy, SaveOptions{QuickTimeText: true})
+		assert.NoError(t, err)
+		assertSameChunks(t, src, buffy.Bytes())
+		bis, err := mp4lib.ExtractBox(bytes.NewReader(buffy.Bytes()), nil, udtaText)
+		assert.NoError(t, err)
+		if assert.Len(t, bis, 1) {
+			assert.Equal(t, "Holiday", parseQuickTimeText(buffy.Bytes()[bis[0].Offset+bis[0].HeaderSize:bis[0].Offset+bis[0].Size]))
+		}
+		bis, err = mp4lib.ExtractBox(bytes.NewReader(buffy.Bytes()), nil, mp4lib.BoxPath{mp4lib.BoxTypeMoov(), mp4lib.BoxTypeUdta(), {'\251', 'm', 'a', 'k'}})
+		assert.NoError(t, err)
+		assert.Len(t, bis, 1)
+
+		saved, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+		assert.NoError(t, err)
+		saved.SetTitle("")
+		buffy = new(bytes.Buffer)
+		err = saved.SaveWithOptions(buffy, SaveOptions{QuickTimeText: true})
+		assert.NoError(t, err)
+		cleared, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+		assert.NoError(t, err)
+		assert.Empty(t, cleared.GetTitle())
+		assert.Equal(t, "2020-01-02", cleared.Year)
+	})
+
+	t.Run("written without udta", func(t *testing.T) {
+		tag, err := ReadMP4(bytes.NewReader(videoFile(t)))
+		assert.NoError(t, err)
+		tag.SetTitle("Holiday")
+		buffy := new(bytes.Buffer)
+		err = tag.SaveWithOptions(buffy, SaveOptions{QuickTimeText: true})
+		assert.NoError(t, err)
+		bis, err := mp4lib.ExtractBox(bytes.NewReader(buffy.Bytes()), nil, udtaText)
+		assert.NoError(t, err)
+		assert.Len(t, bis, 1)
+	})
+}
+
+func TestKeyedM4V(t *testing.T) {
+	data := func(text string) []byte {
+		return boxBytes("data", u32(mp4lib.DataTypeStringUTF8), u32(0), []byte(text))
+	}
+	// an iPhone style meta box without version and flags
+	meta := boxBytes("meta",
+		boxBytes("hdlr", make([]byte, 8), []byte("mdta"), make([]byte, 13)),
+		boxBytes("keys", u32(0), u32(3),
+			boxBytes("mdta", []byte(KeyMake)),
+			boxBytes("mdta", []byte(KeyModel)),
+			boxBytes("mdta", []byte(KeyCreationDate)),
+		),
+		boxBytes("ilst",
+			boxBytes(string(u32(3)), data("2023-06-01T12:00:00+0200")),
+			boxBytes(string(u32(1)), data("Apple")),
+			boxBytes(string(u32(2)), data("iPhone 14")),
+		),
+	)
+	src := videoFile(t, meta)
+
+	tag, err := ReadMP4(bytes.NewReader(src))
+	assert.NoError(t, err)
+	assert.Equal(t, "Apple", tag.GetKeyed(KeyMake))
+	assert.Equal(t, "iPhone 14", tag.GetKeyed(KeyModel))
+	assert.Equal(t, "2023-06-01T12:00:00+0200", tag.GetKeyed(KeyCreationDate))
+	assert.Len(t, tag.GetKeyedItems(), 3)
+
+	t.Run("unchanged is kept as is", func(t *testing.T) {
+		tag.SetTitle("Clip")
+		buffy := new(bytes.Buffer)
+		err := tag.Save(buffy)
+		assert.NoError(t, err)
+		assert.True(t, bytes.Contains(buffy.Bytes(), meta))
+	})
+
+	t.Run("kept with all tags cleared", func(t *testing.T) {
+		tag, err := ReadMP4(bytes.NewReader(src))
+		assert.NoError(t, err)
+		tag.ClearAllTags()
+		assert.Len(t, tag.GetKeyedItems(), 3)
+		buffy := new(bytes.Buffer)
+		err = tag.Save(buffy)
+		assert.NoError(t, err)
+		assert.True(t, bytes.Contains(buffy.Bytes(), meta))
+	})
+
+	tag.SetKeyed(KeySoftware, "17.0")
+	tag.SetKeyed(KeyMake, "Apple Inc.")
+	tag.RemoveKeyed(KeyModel)
+	tag.SetTitle("Clip")
+	buffy := new(bytes.Buffer)
+	err = tag.Save(buffy)
+	assert.NoError(t, err)
+	assertSameChunks(t, src, buffy.Bytes())
+
+	saved, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "Clip", saved.GetTitle())
+	var keys []string
+	for _, item := range saved.GetKeyedItems() {
+		keys = append(keys, item.Key)
+	}
+	assert.Equal(t, []string{KeyCreationDate, KeyMake, KeySoftware}, keys)
+	assert.Equal(t, "Apple Inc.", saved.GetKeyed(KeyMake))
+	assert.Equal(t, "17.0", saved.GetKeyed(KeySoftware))
+	assert.Empty(t, saved.GetKeyed(KeyModel))
+
+	t.Run("keys match the ilst", func(t *testing.T) {
+		bis, err := mp4lib.ExtractBox(bytes.NewReader(buffy.Bytes()), nil, mp4lib.BoxPath{mp4lib.BoxTypeMoov(), mp4lib.BoxTypeMeta()})
+		assert.NoError(t, err)
+		if assert.Len(t, bis, 1) {
+			payload := buffy.Bytes()[bis[0].Offset+bis[0].HeaderSize : bis[0].Offset+bis[0].Size]
+			parsed, ok := parseKeyedMeta(payload)
+			assert.True(t, ok)
+			assert.False(t, parsed.fullBox)
+			var types []string
+			for _, child := range parsed.children {
+				types = append(types, child.boxType.String())
+			}
+			assert.Equal(t, []string{"hdlr", "keys", "ilst"}, types)
+			assert.Len(t, parseKeys(parsed.children[1].data[8:]), 3)
+		}
+	})
+
+	t.Run("added to a file without keys", func(t *testing.T) {
+		tag, err := ReadMP4(bytes.NewReader(videoFile(t)))
+		assert.NoError(t, err)
+		assert.Empty(t, tag.GetKeyedItems())
+		tag.SetKeyed(KeyMake, "Maker")
+		buffy := new(bytes.Buffer)
+		err = tag.Save(buffy)
+		assert.NoError(t, err)
+		saved, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+		assert.NoError(t, err)
+		assert.Equal(t, "Maker", saved.GetKeyed(KeyMake))
+	})
+}
+
+func TestLocationM4V(t *testing.T) {
+	t.Run("parse", func(t *testing.T) {
+		for in, out := range map[string]Location{
+			"+37.7858-122.4064/":                {Latitude: 37.7858, Longitude: -122.4064},
+			"+37.7858-122.4064+012.345/":        {Latitude: 37.7858, Longitude: -122.4064, Altitude: 12.345, HasAltitude: true},
+			"-33.8688+151.2093+010.000CRS4326/": {Latitude: -33.8688, Longitude: 151.2093, Altitude: 10, HasAltitude: true},
+			"+4807.5+01130.0/":                  {Latitude: 48.125, Longitude: 11.5},
+			"+480730+0113018/":                  {Latitude: 48.125, Longitude: 11.505},
+			"+48+011":                           {Latitude: 48, Longitude: 11},
+		} {
+			l, err := ParseISO6709(in)
+			assert.NoError(t, err, in)
+			assert.Equal(t, out.HasAltitude, l.HasAltitude, in)
+			assert.InDelta(t, out.Latitude, l.Latitude, 1e-9, in)
+			assert.InDelta(t, out.Longitude, l.Longitude, 1e-9, in)
+			assert.InDelta(t, out.Altitude, l.Altitude, 1e-9, in)
+		}
+		for _, in := range []string{"", "37.7858,-122.4064", "+91.0000+000.0000/", "+12345678+000/"} {
+			_, err := ParseISO6709(in)
+			assert.Error(t, err, in)
+		}
+		assert.Equal(t, "+37.7858-122.4064+012.345/", Location{Latitude: 37.7858, Longitude: -122.4064, Altitude: 12.345, HasAltitude: true}.ISO6709())
+		assert.Equal(t, "-05.0000+005.0000/", Location{Latitude: -5, Longitude: 5}.ISO6709())
+	})
+
+	var meta []byte
+	{
+		tag := &MP4Tag{}
+		tag.SetKeyed(KeyMake, "Apple")
+		tag.SetKeyed(KeyLocationISO6709, "+37.7858-122.4064+012.345/")
+		tag.SetKeyed(locationKeyPrefix+"accuracy.horizontal", "4.7")
+		ws := &writerseeker.WriterSeeker{}
+		assert.NoError(t, writeKeyedMeta(mp4lib.NewWriter(ws), nil, tag.GetKeyedItems()))
+		meta = ws.Bytes()
+	}
+	udta := boxBytes("udta", boxBytes("\251xyz", quickTimeText("+37.7800-122.4000/", PackLanguage("eng"))))
+	src := videoFile(t, udta, meta)
+	xyzPath := mp4lib.BoxPath{mp4lib.BoxTypeMoov(), mp4lib.BoxTypeUdta(), boxTypeXyz}
+
+	tag, err := ReadMP4(bytes.NewReader(src))
+	assert.NoError(t, err)
+	l, ok := tag.GetLocation()
+	assert.True(t, ok)
+	assert.Equal(t, Location{Latitude: 37.7858, Longitude: -122.4064, Altitude: 12.345, HasAltitude: true}, l)
+
+	tag.SetLocation(Location{Latitude: 51.5007, Longitude: -0.1246})
+	buffy := new(bytes.Buffer)
+	err = tag.Save(buffy)
+	assert.NoError(t, err)
+	assertSameChunks(t, src, buffy.Bytes())
+	saved, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "+51.5007-000.1246/", saved.GetKeyed(KeyLocationISO6709))
+	assert.Equal(t, "+51.5007-000.1246/", saved.xyz)
+	bis, err := mp4lib.ExtractBox(bytes.NewReader(buffy.Bytes()), nil, xyzPath)
+	assert.NoError(t, err)
+	assert.Len(t, bis, 1)
+
+	t.Run("removed", func(t *testing.T) {
+		saved.RemoveLocation()
+		buffy := new(bytes.Buffer)
+		err := saved.Save(buffy)
+		assert.NoError(t, err)
+		stripped, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+		assert.NoError(t, err)
+		_, ok := stripped.GetLocation()
+		assert.False(t, ok)
+		assert.Equal(t, "Apple", stripped.GetKeyed(KeyMake))
+		assert.Len(t, stripped.GetKeyedItems(), 1)
+		bis, err := mp4lib.ExtractBox(bytes.NewReader(buffy.Bytes()), nil, xyzPath)
+		assert.NoError(t, err)
+		assert.Empty(t, bis)
+		assert.False(t, bytes.Contains(buffy.Bytes(), []byte("+51.5007")))
+	})
+
+	t.Run("removed with loci", func(t *testing.T) {
+		// 3GPP location: name, role, 16.16 longitude, latitude and
+		// altitude, astronomical body and notes
+		longitude, latitude := int32(-8026942), int32(2478577) // -122.4783, 37.8199
+		loci := boxBytes("loci", u32(0), []byte{0x15, 0xc7}, []byte("Golden Gate\x00"), []byte{0},
+			u32(uint32(longitude)), u32(uint32(latitude)), u32(0), []byte("earth\x00"), []byte("\x00"))
+		src := videoFile(t, boxBytes("udta", boxBytes("\251xyz", quickTimeText("+37.7800-122.4000/", PackLanguage("eng"))), loci))
+		lociPath := mp4lib.BoxPath{mp4lib.BoxTypeMoov(), mp4lib.BoxTypeUdta(), boxTypeLoci}
+		bis, err := mp4lib.ExtractBox(bytes.NewReader(src), nil, lociPath)
+		assert.NoError(t, err)
+		assert.Len(t, bis, 1)
+
+		tag, err := ReadMP4(bytes.NewReader(src))
+		assert.NoError(t, err)
+		tag.RemoveLocation()
+		buffy := new(bytes.Buffer)
+		err = tag.Save(buffy)
+		assert.NoError(t, err)
+		assertSameChunks(t, src, buffy.Bytes())
+		bis, err = mp4lib.ExtractBox(bytes.NewReader(buffy.Bytes()), nil, lociPath)
+		assert.NoError(t, err)
+		assert.Empty(t, bis)
+		assert.False(t, bytes.Contains(buffy.Bytes(), []byte("Golden Gate")))
+	})
+
+	t.Run("audio file", func(t *testing.T) {
+		b, err := os.ReadFile("./testdata/test1.m4a")
+		assert.NoError(t, err)
+		tag, err := ReadMP4(bytes.NewReader(b))
+		assert.NoError(t, err)
+		_, ok := tag.GetLocation()
+		assert.False(t, ok)
+		tag.SetLocation(Location{Latitude: 1.5, Longitude: 2.5, Altitude: -3, HasAltitude: true})
+		buffy := new(bytes.Buffer)
+		err = tag.Save(buffy)
+		assert.NoError(t, err)
+		saved, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+		assert.NoError(t, err)
+		l, ok := saved.GetLocation()
+		assert.True(t, ok)
+		assert.Equal(t, Location{Latitude: 1.5, Longitude: 2.5, Altitude: -3, HasAltitude: true}, l)
+		assert.Empty(t, saved.GetKeyedItems())
+	})
+}
+
+// boxBytes returns a box of the given type holding the payloads.
+func boxBytes(boxType string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	buf := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(buf, uint32(8+len(body)))
+	copy(buf[4:], boxType)
+	return append(buf, body...)
+}
+
+// u32 returns n big endian.
+func u32(n uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, n)
+	return b
+}
+
+// videoFile builds an m4v file with a single AVC video track of three
+// samples, moov before mdat and no metadata. extra boxes are appended to moov.
+func videoFile(t *testing.T, extra ...[]byte) []byte {
+	samples := [][]byte{
+		bytes.Repeat([]byte{0xA1}, 40),
+		bytes.Repeat([]byte{0xB2}, 24),
+		bytes.Repeat([]byte{0xC3}, 32),
+	}
+	build := func(mdatOffset uint32) []byte {
+		ws := &writerseeker.WriterSeeker{}
+		w := mp4lib.NewWriter(ws)
+		var box func(boxType mp4lib.BoxType, payload mp4lib.IBox, children ...func())
+		box = func(boxType mp4lib.BoxType, payload mp4lib.IBox, children ...func()) {
+			bi, err := w.StartBox(&mp4lib.BoxInfo{Type: boxType})
+			assert.NoError(t, err)
+			if payload != nil {
+				_, err = mp4lib.Marshal(w, payload, bi.Context)
+				assert.NoError(t, err)
+			}
+			for _, child := range children {
+				child()
+			}
+			_, err = w.EndBox()
+			assert.NoError(t, err)
+		}
+		box(mp4lib.BoxTypeFtyp(), &mp4lib.Ftyp{
+			MajorBrand: [4]byte{'M', '4', 'V', ' '},
+			CompatibleBrands: []mp4lib.CompatibleBrandElem{
+				{CompatibleBrand: [4]byte{'M', '4', 'V', ' '}},
+				{CompatibleBrand: [4]byte{'m', 'p', '4', '2'}},
+				{CompatibleBrand: [4]byte{'i', 's', 'o', 'm'}},
+			},
+		})
+		box(mp4lib.BoxTypeMoov(), nil,
+			func() {
+				box(mp4lib.BoxTypeMvhd(), &mp4lib.Mvhd{Timescale: 600, DurationV0: 1800, Rate: 0x10000, Volume: 0x100, NextTrackID: 2,
+					Matrix: [9]int32{0x10000, 0, 0, 0, 0x10000, 0, 0, 0, 0x40000000}})
+			},
+			func() {
+				box(mp4lib.BoxTypeTrak(), nil,
+					func() {
+						tkhd := &mp4lib.Tkhd{TrackID: 1, DurationV0: 1800, Width: 320 << 16, Height: 240 << 16,
+							Matrix: [9]int32{0x10000, 0, 0, 0, 0x10000, 0, 0, 0, 0x40000000}}
+						tkhd.SetFlags(3)
+						box(mp4lib.BoxTypeTkhd(), tkhd)
+					},
+					func() {
+						box(mp4lib.BoxTypeMdia(), nil,
+							func() {
+								box(mp4lib.BoxTypeMdhd(), &mp4lib.Mdhd{Timescale: 600, DurationV0: 1800, Language: [3]byte{'u' - 0x60, 'n' - 0x60, 'd' - 0x60}})
+							},
+							func() {
+								box(mp4lib.BoxTypeHdlr(), &mp4lib.Hdlr{HandlerType: [4]byte{'v', 'i', 'd', 'e'}, Name: "VideoHandler"})
+							},
+							func() {
+								box(mp4lib.BoxTypeMinf(), nil,
+									func() { box(mp4lib.BoxTypeVmhd(), &mp4lib.Vmhd{}) },
+									func() {
+										box(mp4lib.BoxTypeStbl(), nil,
+											func() {
+												box(mp4lib.BoxTypeStsd(), &mp4lib.Stsd{EntryCount: 1}, func() {
+													box(mp4lib.BoxTypeAvc1(), &mp4lib.VisualSampleEntry{
+														SampleEntry:     mp4lib.SampleEntry{AnyTypeBox: mp4lib.AnyTypeBox{Type: mp4lib.BoxTypeAvc1()}, DataReferenceIndex: 1},
+														Width:           320,
+														Height:          240,
+														Horizresolution: 0x480000,
+														Vertresolution:  0x480000,
+														FrameCount:      1,
+														Depth:           0x18,
+														PreDefined3:     -1,
+													})
+												})
+											},
+											func() {
+												box(mp4lib.BoxTypeStts(), &mp4lib.Stts{EntryCount: 1, Entries: []mp4lib.SttsEntry{{SampleCount: 3, SampleDelta: 600}}})
+											},
+											func() {
+												box(mp4lib.BoxTypeStsc(), &mp4lib.Stsc{EntryCount: 1, Entries: []mp4lib.StscEntry{{FirstChunk: 1, SamplesPerChunk: 1, SampleDescriptionIndex: 1}}})
+											},
+											func() {
+												box(mp4lib.BoxTypeStsz(), &mp4lib.Stsz{SampleCount: 3, EntrySize: []uint32{40, 24, 32}})
+											},
+											func() {
+												box(mp4lib.BoxTypeStco(), &mp4lib.Stco{EntryCount: 3, ChunkOffset: []uint32{mdatOffset + 8, mdatOffset + 48, mdatOffset + 72}})
+											},
+										)
+									},
+								)
+							},
+						)
+					},
+				)
+			},
+			func() {
+				for _, b := range extra {
+					_, err := w.Write(b)
+					assert.NoError(t, err)
+				}
+			},
+		)
+		box(mp4lib.BoxTypeMdat(), &mp4lib.Mdat{Data: bytes.Join(samples, nil)})
+		return append([]byte(nil), ws.Bytes()...)
+	}
+	b := build(0)
+	return build(uint32(len(b)) - 8 - 96)
+}
+
+// ilstItems returns the first data box of every ilst item of the mp4 file b.
+func ilstItems(t *testing.T, b []byte) map[mp4lib.BoxType]mp4lib.Data {
+	ilst, err := mp4lib.ExtractBox(bytes.NewReader(b), nil, ilstPath)
+	assert.NoError(t, err)
+	assert.Len(t, ilst, 1)
+	items := map[mp4lib.BoxType]mp4lib.Data{}
+	payload := b[ilst[0].Offset+ilst[0].HeaderSize : ilst[0].Offset+ilst[0].Size]
+	for len(payload) >= 8 {
+		size := binary.BigEndian.Uint32(payload)
+		if data := parseDataBoxes(payload[8:size]); len(data) > 0 {
+			items[mp4lib.BoxType{payload[4], payload[5], payload[6], payload[7]}] = *data[0]
+		}
+		payload = payload[size:]
+	}
+	return items
+}
+
+func TestBPMAndGenreM4A(t *testing.T) {
+	src, err := os.ReadFile("./testdata/testdata-m4a-nonEmpty.m4a")
+	assert.NoError(t, err)
+	tag, err := ReadMP4(bytes.NewReader(src))
+	assert.NoError(t, err)
+	tag.SetBPM(127)
+	tag.SetGenre("Rock")
+	buffy := new(bytes.Buffer)
+	err = tag.Save(buffy)
+	assert.NoError(t, err)
+
+	items := ilstItems(t, buffy.Bytes())
+	// tmpo is a 16-bit integer
+	assert.Equal(t, []byte{0, 127}, items[mp4lib.BoxType{'t', 'm', 'p', 'o'}].Data)
+	// genre is written as ©gen only
+	assert.Equal(t, []byte("Rock"), items[mp4lib.BoxType{'\251', 'g', 'e', 'n'}].Data)
+	assert.NotContains(t, items, mp4lib.BoxType{'g', 'n', 'r', 'e'})
+	saved, err := ReadMP4(bytes.NewReader(buffy.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, 127, saved.GetBPM())
+	assert.Equal(t, "Rock", saved.GetGenre())
+}
+
+func TestAudioPropertiesM4A(t *testing.T) {
+	t.Run("aac", func(t *testing.T) {
+		f, err := os.Open("./testdata/test1.m4a")
+		assert.NoError(t, err)
+		defer f.Close()
+		tag, err := ReadMP4(f)
+		assert.NoError(t, err)
+		props := tag.GetAudioProperties()
+		if assert.NotNil(t, props) {
+			assert.Equal(t, "aac", props.Codec)
+			assert.Equal(t, 2, props.AACObjectType)
+			assert.Equal(t, 44100, props.SampleRate)
+			assert.Equal(t, 2, props.Channels)
+			assert.Equal(t, 0, props.BitsPerSample)
+			assert.Equal(t, 3436, int(props.Duration.Milliseconds()))
+			assert.InDelta(t, 124000, props.Bitrate, 1000)
+		}
+	})
+
+	t.Run("no audio", func(t *testing.T) {
+		tag, err := ReadMP4(bytes.NewReader(videoFile(t)))
+		assert.NoError(t, err)
+		assert.Nil(t, tag.GetAudioProperties())
+	})
+
+	// stsd returns an stsd payload with one audio sample entry
+	stsd := func(entryType string, version uint16, channels uint16, rate uint32, children ...[]byte) []byte {
+		fields := make([]byte, 8+20)
+		binary.BigEndian.PutUint16(fields[6:], 1)
+		binary.BigEndian.PutUint16(fields[8:], version)
+		binary.BigEndian.PutUint16(fields[16:], channels)
+		binary.BigEndian.PutUint16(fields[18:], 16)
+		binary.BigEndian.PutUint32(fields[24:], rate<<16)
+		switch version {
+		case 1:
+			fields = append(fields, make([]byte, 16)...)
+		case 2:
+			v2 := make([]byte, 36)
+			binary.BigEndian.PutUint64(v2[4:], math.Float64bits(float64(rate)))
+			binary.BigEndian.PutUint32(v2[12:], uint32(channels))
+			fields = append(fields, v2...)
+			binary.BigEndian.PutUint32(fields[24:], 0x10000)
+		}
+		header := make([]byte, 8)
+		binary.BigEndian.PutUint32(header[4:], 1)
+		return append(header, boxBytes(entryType, append(fields, bytes.Join(children, nil)...))...)
+	}
+	descriptor := func(tag byte, body ...[]byte) []byte {
+		b := bytes.Join(body, nil)
+		return append([]byte{tag, byte(len(b))}, b...)
+	}
+	esds := func(oti byte, avgBitrate uint32, asc ...byte) []byte {
+		config := make([]byte, 13)
+		config[0] = oti
+		config[1] = 0x15
+		binary.BigEndian.PutUint32(config[9:], avgBitrate)
+		if asc != nil {
+			config = append(config, descriptor(0x05, asc)...)
+		}
+		es := descriptor(0x03, []byte{0, 1, 0}, descriptor(0x04, config), descriptor(0x06, []byte{0x02}))
+		return boxBytes("esds", make([]byte, 4), es)
+	}
+	alac := make([]byte, 4+24)
+	binary.BigEndian.PutUint32(alac[4:], 4096)
+	alac[9] = 24
+	alac[13] = 2
+	binary.BigEndian.PutUint32(alac[20:], 2000000)
+	binary.BigEndian.PutUint32(alac[24:], 96000)
+	streamInfo := make([]byte, 34)
+	copy(streamInfo[10:], []byte{0x0A, 0xC4, 0x42, 0xF0})
+
+	for _, test := range []struct {
+		name      string
+		stsd      []byte
+		mediaSize uint64
+		want      AudioProperties
+	}{
+		{
+			name:      "aac lc",
+			stsd:      stsd("mp4a", 0, 2, 44100, esds(0x40, 128000, 0x12, 0x10)),
+			mediaSize: 64000,
+			want:      AudioProperties{Codec: "aac", AACObjectType: 2, SampleRate: 44100, Channels: 2, Bitrate: 256000},
+		},
+		{
+			name: "he-aac v2 in a wave box",
+			stsd: stsd("mp4a", 1, 2, 24000, boxBytes("wave", boxBytes("frma", []byte("mp4a")), esds(0x40, 32000, 0xEB, 0x09, 0x88, 0x00))),
+			want: AudioProperties{Codec: "aac", AACObjectType: 29, SampleRate: 48000, Channels: 2, Bitrate: 32000},
+		},
+		{
+			name: "mp3",
+			stsd: stsd(".mp3", 0, 2, 44100),
+			want: AudioProperties{Codec: ".mp3", SampleRate: 44100, Channels: 2},
+		},
+		{
+			name: "mp3 in mp4a",
+			stsd: stsd("mp4a", 0, 1, 32000, esds(0x6B, 96000)),
+			want: AudioProperties{Codec: "mp3", SampleRate: 32000, Channels: 1, Bitrate: 96000},
+		},
+		{
+			name: "alac",
+			stsd: stsd("alac", 0, 2, 0, boxBytes("alac", alac)),
+			want: AudioProperties{Codec: "alac", SampleRate: 96000, Channels: 2, BitsPerSample: 24, Bitrate: 2000000},
+		},
+		{
+			name: "opus",
+			stsd: stsd("Opus", 0, 2, 48000, boxBytes("dOps", []byte{0, 6, 0x01, 0x38, 0, 0, 0xBB, 0x80, 0, 0, 0})),
+			want: AudioProperties{Codec: "opus", SampleRate: 48000, Channels: 6},
+		},
+		{
+			name: "flac",
+			stsd: stsd("fLaC", 0, 2, 44100, boxBytes("dfLa", make([]byte, 4), []byte{0x80, 0, 0, 34}, streamInfo)),
+			want: AudioProperties{Codec: "flac", SampleRate: 44100, Channels: 2, BitsPerSample: 16},
+		},
+		{
+			name: "ac-3",
+			stsd: stsd("ac-3", 0, 2, 48000, boxBytes("dac3", []byte{0x10, 0x3D, 0xE0})),
+			want: AudioProperties{Codec: "ac-3", SampleRate: 48000, Channels: 6, Bitrate: 448000},
+		},
+		{
+			name: "ec-3",
+			stsd: stsd("ec-3", 0, 2, 48000, boxBytes("dec3", []byte{0x14, 0x00, 0x20, 0x0F, 0x00})),
+			want: AudioProperties{Codec: "ec-3", SampleRate: 48000, Channels: 6, Bitrate: 640000},
+		},
+		{
+			name: "quicktime v2",
+			stsd: stsd("lpcm", 2, 6, 96000),
+			want: AudioProperties{Codec: "lpcm", SampleRate: 96000, Channels: 6},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			movie := &movieInfo{timescale: 600, duration: 1200, tracks: []*trackInfo{
+				{handler: [4]byte{'v', 'i', 'd', 'e'}},
+				{handler: [4]byte{'s', 'o', 'u', 'n'}, timescale: 48000, duration: 96000, stsd: test.stsd, mediaSize: test.mediaSize},
+			}}
+			test.want.Duration = 2 * time.Second
+			assert.Equal(t, &test.want, readAudioProperties(movie))
+		})
+	}
+
+	t.Run("duration of the movie", func(t *testing.T) {
+		movie := &movieInfo{timescale: 600, duration: 1800, tracks: []*trackInfo{
+			{handler: [4]byte{'s', 'o', 'u', 'n'}, timescale: 48000},
+		}}
+		assert.Equal(t, 3*time.Second, readAudioProperties(movie).Duration)
+	})
+
+	t.Run("unreadable track left out", func(t *testing.T) {
+		b, err := os.ReadFile("./testdata/test1.m4a")
+		assert.NoError(t, err)
+		tag, err := ReadMP4(bytes.NewReader(b))
+		assert.NoError(t, err)
+		bis, err := mp4lib.ExtractBox(bytes.NewReader(b), nil, mp4lib.BoxPath{mp4lib.BoxTypeMoov(), mp4lib.BoxTypeTrak(), mp4lib.BoxTypeMdia(), mp4lib.BoxTypeMdhd()})
+		assert.NoError(t, err)
+		if assert.Len(t, bis, 1) {
+			// mdhd claims to be larger than mdia
+			broken := append([]byte(nil), b...)
+			binary.BigEndian.PutUint32(broken[bis[0].Offset:], 0x7FFFFFFF)
+			saved, err := ReadMP4(bytes.NewReader(broken))
+			assert.NoError(t, err)
+			assert.Equal(t, tag.GetTitle(), saved.GetTitle())
+			assert.Nil(t, saved.GetAudioProperties())
+		}
+	})
+}
+
+func TestVideoPropertiesM4V(t *testing.T) {
+	t.Run("m4v", func(t *testing.T) {
+		tag, err := ReadMP4(bytes.NewReader(videoFile(t)))
+		assert.NoError(t, err)
+		assert.Equal(t, []VideoProperties{{
+			TrackID:            1,
+			Duration:           3 * time.Second,
+			Codec:              "h264",
+			Width:              320,
+			Height:             240,
+			DisplayWidth:       320,
+			DisplayHeight:      240,
+			DisplayAspectRatio: 320.0 / 240,
+			FrameRate:          1,
+			Bitrate:            96 * 8 / 3,
+		}}, tag.GetVideoProperties())
+		assert.Nil(t, tag.GetAudioProperties())
+	})
+
+	t.Run("audio only", func(t *testing.T) {
+		f, err := os.Open("./testdata/test1.m4a")
+		assert.NoError(t, err)
+		defer f.Close()
+		tag, err := ReadMP4(f)
+		assert.NoError(t, err)
+		assert.Empty(t, tag.GetVideoProperties())
+	})
+
+	// stsd returns an stsd payload with one visual sample entry
+	stsd := func(entryType string, width, height uint16, children ...[]byte) []byte {
+		fields := make([]byte, 8+70)
+		binary.BigEndian.PutUint16(fields[6:], 1)
+		binary.BigEndian.PutUint16(fields[8+16:], width)
+		binary.BigEndian.PutUint16(fields[8+18:], height)
+		header := make([]byte, 8)
+		binary.BigEndian.PutUint32(header[4:], 1)
+		return append(header, boxBytes(entryType, append(fields, bytes.Join(children, nil)...))...)
+	}
+	pair := func(a, b uint32) []byte {
+		buf := make([]byte, 8)
+		binary.BigEndian.PutUint32(buf, a)
+		binary.BigEndian.PutUint32(buf[4:], b)
+		return buf
+	}
+	hvcC := make([]byte, 23)
+	hvcC[0] = 1
+	hvcC[1] = 2
+	hvcC[12] = 153
+	identity := [9]int32{0x10000, 0, 0, 0, 0x10000, 0, 0, 0, 0x40000000}
+
+	for _, test := range []struct {
+		name   string
+		stsd   []byte
+		matrix [9]int32
+		want   VideoProperties
+	}{
+		{
+			name: "h264 anamorphic",
+			stsd: stsd("avc1", 720, 576,
+				boxBytes("avcC", []byte{1, 100, 0, 31, 0xFF, 0xE0, 0}),
+				boxBytes("pasp", pair(16, 15)),
+				boxBytes("colr", []byte("nclx"), []byte{0, 1, 0, 1, 0, 1, 0})),
+			matrix: identity,
+			want: VideoProperties{Codec: "h264", Profile: 100, Level: 31, Width: 720, Height: 576, DisplayAspectRatio: 720.0 * 16 / (576 * 15),
+				ColorPrimaries: 1, TransferCharacteristics: 1, MatrixCoefficients: 1},
+		},
+		{
+			name: "hevc hdr portrait",
+			stsd: stsd("hvc1", 1920, 1080,
+				boxBytes("hvcC", hvcC),
+				boxBytes("colr", []byte("nclx"), []byte{0, 9, 0, 16, 0, 9, 0x80})),
+			matrix: [9]int32{0, 0x10000, 0, -0x10000, 0, 0, 1080 << 16, 0, 0x40000000},
+			want: VideoProperties{Codec: "hevc", Profile: 2, Level: 153, Width: 1920, Height: 1080, DisplayAspectRatio: 1920.0 / 1080, Rotation: 90,
+				ColorPrimaries: 9, TransferCharacteristics: 16, MatrixCoefficients: 9, FullRange: true},
+		},
+		{
+			name:   "av1 upside down",
+			stsd:   stsd("av01", 1280, 720, boxBytes("av1C", []byte{0x81, 0x08, 0x0C, 0})),
+			matrix: [9]int32{-0x10000, 0, 0, 0, -0x10000, 0, 1280 << 16, 720 << 16, 0x40000000},
+			want:   VideoProperties{Codec: "av1", Profile: 0, Level: 8, Width: 1280, Height: 720, DisplayAspectRatio: 1280.0 / 720, Rotation: 180},
+		},
+		{
+			name: "quicktime nclc",
+			stsd: stsd("apcn", 1920, 1080, boxBytes("colr", []byte("nclc"), []byte{0, 1, 0, 1, 0, 1})),
+			want: VideoProperties{Codec: "apcn", Width: 1920, Height: 1080, DisplayAspectRatio: 1920.0 / 1080,
+				ColorPrimaries: 1, TransferCharacteristics: 1, MatrixCoefficients: 1},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			movie := &movieInfo{tracks: []*trackInfo{
+				{handler: [4]byte{'s', 'o', 'u', 'n'}},
+				{id: 2, handler: [4]byte{'v', 'i', 'd', 'e'}, timescale: 30000, duration: 60060, sampleCount: 60, sampleTime: 60060,
+					stsd: test.stsd, matrix: test.matrix},
+			}}
+			test.want.TrackID = 2
+			test.want.Duration = 2002 * time.Millisecond
+			test.want.FrameRate = 30000.0 / 1001
+			assert.Equal(t, []VideoProperties{test.want}, readVideoProperties(movie))
+		})
+	}
+}
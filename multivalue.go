@@ -0,0 +1,62 @@
+package mp4meta
+
+import mp4lib "github.com/abema/go-mp4"
+
+// MultiValueSeparator joins the values of a multi-valued item into its
+// string field, e.g. Artist for the ©ART values.
+const MultiValueSeparator = "; "
+
+// multiValueFields are the fields whose items may hold several data boxes.
+var multiValueFields = map[string]bool{
+	"Artist":      true,
+	"AlbumArtist": true,
+	"Composer":    true,
+	"Genre":       true,
+}
+
+func (m *MP4Tag) GetArtists() []string {
+	return m.multiValue("Artist", m.Artist)
+}
+
+func (m *MP4Tag) GetAlbumArtists() []string {
+	return m.multiValue("AlbumArtist", m.AlbumArtist)
+}
+
+func (m *MP4Tag) GetComposers() []string {
+	return m.multiValue("Composer", m.Composer)
+}
+
+func (m *MP4Tag) GetGenres() []string {
+	return m.multiValue("Genre", m.Genre)
+}
+
+func (m *MP4Tag) SetArtists(artists []string) {
+	m.setMultiValue("Artist", artists)
+}
+func (m *MP4Tag) SetAlbumArtists(albumArtists []string) {
+	m.setMultiValue("AlbumArtist", albumArtists)
+}
+func (m *MP4Tag) SetComposers(composers []string) {
+	m.setMultiValue("Composer", composers)
+}
+func (m *MP4Tag) SetGenres(genres []string) {
+	m.setMultiValue("Genre", genres)
+}
+
+// multiValue returns the texts of the values of the field.
+func (m *MP4Tag) multiValue(field, value string) []string {
+	var texts []string
+	for _, v := range m.textValues(field, value) {
+		texts = append(texts, v.Text)
+	}
+	return texts
+}
+
+// setMultiValue replaces the values of the field by UTF-8 texts.
+func (m *MP4Tag) setMultiValue(field string, texts []string) {
+	values := make([]TextValue, len(texts))
+	for i, text := range texts {
+		values[i] = TextValue{Text: text, DataType: mp4lib.DataTypeStringUTF8}
+	}
+	_ = m.SetTextValues(field, values)
+}
@@ -0,0 +1,124 @@
+package mp4meta
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"unicode/utf8"
+
+	mp4lib "github.com/abema/go-mp4"
+)
+
+// quickTimeTextAtoms are the QuickTime text atoms of moov/udta and the
+// fields they fill, in the order they are written.
+var quickTimeTextAtoms = []struct {
+	boxType mp4lib.BoxType
+	field   string
+}{
+	{mp4lib.BoxType{'\251', 'n', 'a', 'm'}, "Title"},
+	{mp4lib.BoxType{'\251', 'A', 'R', 'T'}, "Artist"},
+	{mp4lib.BoxType{'\251', 'a', 'l', 'b'}, "Album"},
+	{mp4lib.BoxType{'\251', 'd', 'a', 'y'}, "Year"},
+	{mp4lib.BoxType{'\251', 'c', 'm', 't'}, "Comments"},
+	{mp4lib.BoxType{'\251', 'w', 'r', 't'}, "Composer"},
+	{mp4lib.BoxType{'\251', 'c', 'p', 'y'}, "Copyright"},
+	{mp4lib.BoxType{'\251', 'd', 'e', 's'}, "Description"},
+	{mp4lib.BoxType{'\251', 'g', 'e', 'n'}, "Genre"},
+	{mp4lib.BoxType{'\251', 't', 'o', 'o'}, "Encoder"},
+	{mp4lib.BoxType{'\251', 'g', 'r', 'p'}, "Grouping"},
+	{mp4lib.BoxType{'\251', 'l', 'y', 'r'}, "Lyrics"},
+}
+
+// macRoman holds the characters 0x80 to 0xFF of the Mac OS Roman encoding.
+const macRoman = "ÄÅÇÉÑÖÜáàâäãåçéèêëíìîïñóòôöõúùûü†°¢£§•¶ß®©™´¨≠ÆØ∞±≤≥¥µ∂∑∏π∫ªºΩæø" +
+	"¿¡¬√ƒ≈∆«»… ÀÃÕŒœ–—“”‘’÷◊ÿŸ⁄€‹›ﬁﬂ‡·‚„‰ÂÊÁËÈÍÎÏÌÓÔÒÚÛÙıˆ˜¯˘˙˚¸˝˛ˇ"
+
+// quickTimeTextField returns the field of a moov/udta text atom, or "".
+func quickTimeTextField(boxType mp4lib.BoxType) string {
+	for _, atom := range quickTimeTextAtoms {
+		if atom.boxType == boxType {
+			return atom.field
+		}
+	}
+	return ""
+}
+
+// parseQuickTimeText returns the first string of the payload of a QuickTime
+// text atom. Each string has a 16-bit size and language code. Strings with a
+// Macintosh language code are Mac OS Roman, unless they are valid UTF-8.
+func parseQuickTimeText(payload []byte) string {
+	if len(payload) < 4 {
+		return ""
+	}
+	n := int(binary.BigEndian.Uint16(payload))
+	language := binary.BigEndian.Uint16(payload[2:])
+	text := payload[4:]
+	if n < len(text) {
+		text = text[:n]
+	}
+	if language >= 0x400 || utf8.Valid(text) {
+		return decodeText(text)
+	}
+	roman := []rune(macRoman)
+	runes := make([]rune, len(text))
+	for i, c := range text {
+		runes[i] = rune(c)
+		if c >= 0x80 {
+			runes[i] = roman[c-0x80]
+		}
+	}
+	return string(runes)
+}
+
+// quickTimeText returns the payload of a QuickTime text atom holding text as
+// UTF-8 of a packed ISO 639-2/T language.
+func quickTimeText(text string, language uint16) []byte {
+	text = truncateUTF8(text, math.MaxUint16)
+	payload := make([]byte, 4, 4+len(text))
+	binary.BigEndian.PutUint16(payload, uint16(len(text)))
+	binary.BigEndian.PutUint16(payload[2:], language)
+	return append(payload, text...)
+}
+
+// quickTimeTextChanged reports whether field was read with a moov/udta text
+// atom and has changed since.
+func (m *MP4Tag) quickTimeTextChanged(field string) bool {
+	text, ok := m.quickTimeTexts[field]
+	return ok && reflect.ValueOf(*m).FieldByName(field).String() != text
+}
+
+// anyQuickTimeTextChanged reports whether a moov/udta text atom has to be
+// rewritten or removed.
+func (m *MP4Tag) anyQuickTimeTextChanged() bool {
+	for field := range m.quickTimeTexts {
+		if m.quickTimeTextChanged(field) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeQuickTimeText writes a QuickTime text atom for every non-empty field
+// that has one, or only for the changed fields unless all is set.
+func writeQuickTimeText(w mp4Writer, _tags *MP4Tag, all bool) error {
+	for _, atom := range quickTimeTextAtoms {
+		text := reflect.ValueOf(*_tags).FieldByName(atom.field).String()
+		if text == "" || !all && !_tags.quickTimeTextChanged(atom.field) {
+			continue
+		}
+		if err := writeRawBox(w, atom.boxType, quickTimeText(text, PackLanguage("und"))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeUdtaText writes the text atoms of moov/udta that are rebuilt from the
+// tags: the QuickTime text atoms if requested or changed and a changed
+// location.
+func writeUdtaText(w mp4Writer, _tags *MP4Tag, opts *SaveOptions) error {
+	if err := writeQuickTimeText(w, _tags, opts.QuickTimeText); err != nil {
+		return err
+	}
+	return writeXyz(w, _tags)
+}
@@ -0,0 +1,710 @@
+package mp4meta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+
+	mp4lib "github.com/abema/go-mp4"
+)
+
+var (
+	boxTypeTref = mp4lib.BoxType{'t', 'r', 'e', 'f'}
+	boxTypeChap = mp4lib.BoxType{'c', 'h', 'a', 'p'}
+)
+
+// movieInfo is what the moov box tells about the movie and its tracks.
+type movieInfo struct {
+	timescale   uint32
+	duration    uint64
+	nextTrackID uint32
+	tracks      []*trackInfo
+	// chpl holds the Nero chapters of moov/udta, if any
+	chpl     []Chapter
+	hasUdta  bool
+	fileSize uint64
+	// fragmented is set if the file has moof boxes, their samples aren't
+	// listed in the sample tables
+	fragmented bool
+}
+
+// trackInfo is a trak box. The sample tables are only read for text tracks,
+// the tables of audio and video tracks can be huge, so only their stsd and
+// totals are kept.
+type trackInfo struct {
+	offset      uint64
+	id          uint32
+	handler     [4]byte
+	name        string
+	timescale   uint32
+	duration    uint64
+	hasTref     bool
+	chapterIDs  []uint32
+	sampleEntry mp4lib.BoxType
+	stts        []mp4lib.SttsEntry
+	stsc        []mp4lib.StscEntry
+	sampleSize  uint32
+	sampleSizes []uint32
+	sampleCount uint32
+	chunks      []uint64
+	// firstChunk and lastChunk are the lowest and highest chunk offsets of
+	// a track whose chunk offset table isn't kept, chunkCount its entries
+	firstChunk uint64
+	lastChunk  uint64
+	chunkCount uint32
+	// stsd is the payload of the stsd box of an audio or video track
+	stsd []byte
+	// mediaSize is the sum of the sample sizes, sampleTime the sum of the
+	// sample durations of an audio or video track
+	mediaSize  uint64
+	sampleTime uint64
+	// width and height are the 16.16 presentation size of tkhd, matrix its
+	// transformation
+	width  uint32
+	height uint32
+	matrix [9]int32
+}
+
+// isAudio reports whether the track is a sound track.
+func (t *trackInfo) isAudio() bool {
+	return t.handler == [4]byte{'s', 'o', 'u', 'n'}
+}
+
+// isVideo reports whether the track is a video track.
+func (t *trackInfo) isVideo() bool {
+	return t.handler == [4]byte{'v', 'i', 'd', 'e'}
+}
+
+// sample is a sample of a track with its place in the file and its media time.
+type sample struct {
+	offset   uint64
+	size     uint32
+	start    uint64
+	duration uint64
+}
+
+// isText reports whether the track is a timed text track.
+func (t *trackInfo) isText() bool {
+	return t.handler == [4]byte{'t', 'e', 'x', 't'} || t.handler == [4]byte{'s', 'b', 't', 'l'}
+}
+
+// movieReader gathers the movie header and the tracks of the moov box while
+// the boxes of a file are walked.
+type movieReader struct {
+	movie *movieInfo
+	track *trackInfo
+	// lenient leaves out the tracks whose boxes can't be read instead of
+	// failing, for reading tags from files other tools manage to play
+	lenient bool
+}
+
+// readMovie reads the movie header and the tracks of the moov box.
+func readMovie(r io.ReadSeeker) (*movieInfo, error) {
+	mr := &movieReader{movie: new(movieInfo)}
+	if _, err := mp4lib.ReadBoxStructure(r, mr.readBox); err != nil {
+		return nil, err
+	}
+	return mr.movie, nil
+}
+
+// readBox is the mp4lib.ReadHandler of a movieReader. Boxes that don't tell
+// about the movie are skipped.
+func (mr *movieReader) readBox(h *mp4lib.ReadHandle) (interface{}, error) {
+	movie, track := mr.movie, mr.track
+	if len(h.Path) == 1 && h.BoxInfo.Offset+h.BoxInfo.Size > movie.fileSize {
+		movie.fileSize = h.BoxInfo.Offset + h.BoxInfo.Size
+	}
+	if len(h.Path) == 1 && h.BoxInfo.Type == mp4lib.BoxTypeMoof() {
+		movie.fragmented = true
+	}
+	switch h.BoxInfo.Type {
+	case mp4lib.BoxTypeMoov(), mp4lib.BoxTypeMdia(), mp4lib.BoxTypeMinf(), mp4lib.BoxTypeStbl():
+		return h.Expand()
+	case mp4lib.BoxTypeTrak():
+		if len(h.Path) != 2 {
+			return nil, nil
+		}
+		track := &trackInfo{offset: h.BoxInfo.Offset}
+		mr.track = track
+		if _, err := h.Expand(); err != nil {
+			if mr.lenient {
+				return nil, nil
+			}
+			return nil, err
+		}
+		movie.tracks = append(movie.tracks, track)
+		return nil, nil
+	case mp4lib.BoxTypeUdta():
+		if len(h.Path) != 2 {
+			return nil, nil
+		}
+		movie.hasUdta = true
+		return h.Expand()
+	case boxTypeChpl:
+		if len(h.Path) != 3 {
+			return nil, nil
+		}
+		buf := new(bytes.Buffer)
+		if _, err := h.ReadData(buf); err != nil {
+			return nil, err
+		}
+		movie.chpl = parseChpl(buf.Bytes())
+	case mp4lib.BoxTypeMvhd():
+		box, _, err := h.ReadPayload()
+		if err != nil {
+			return nil, err
+		}
+		mvhd := box.(*mp4lib.Mvhd)
+		movie.timescale = mvhd.Timescale
+		movie.duration = mvhd.GetDuration()
+		movie.nextTrackID = mvhd.NextTrackID
+	}
+	if track == nil || len(h.Path) < 3 || h.Path[1] != mp4lib.BoxTypeTrak() {
+		return nil, nil
+	}
+	switch h.BoxInfo.Type {
+	case mp4lib.BoxTypeTkhd():
+		box, _, err := h.ReadPayload()
+		if err != nil {
+			return nil, err
+		}
+		tkhd := box.(*mp4lib.Tkhd)
+		track.id = tkhd.TrackID
+		track.width = tkhd.Width
+		track.height = tkhd.Height
+		track.matrix = tkhd.Matrix
+	case boxTypeTref:
+		buf := new(bytes.Buffer)
+		if _, err := h.ReadData(buf); err != nil {
+			return nil, err
+		}
+		track.hasTref = true
+		track.chapterIDs = parseTrackReferences(buf.Bytes(), boxTypeChap)
+	case mp4lib.BoxTypeMdhd():
+		box, _, err := h.ReadPayload()
+		if err != nil {
+			return nil, err
+		}
+		mdhd := box.(*mp4lib.Mdhd)
+		track.timescale = mdhd.Timescale
+		track.duration = mdhd.GetDuration()
+	case mp4lib.BoxTypeHdlr():
+		// QuickTime puts a data handler into minf as well
+		if len(h.Path) != 4 {
+			return nil, nil
+		}
+		box, _, err := h.ReadPayload()
+		if err != nil {
+			return nil, err
+		}
+		hdlr := box.(*mp4lib.Hdlr)
+		track.handler = hdlr.HandlerType
+		track.name = hdlr.Name
+	case mp4lib.BoxTypeStsd():
+		if track.isAudio() || track.isVideo() {
+			buf := new(bytes.Buffer)
+			if _, err := h.ReadData(buf); err != nil {
+				return nil, err
+			}
+			track.stsd = buf.Bytes()
+			return nil, nil
+		}
+		if !track.isText() {
+			return nil, nil
+		}
+		return h.Expand()
+	case mp4lib.BoxTypeStts(), mp4lib.BoxTypeStsz():
+		if !track.isText() {
+			if track.isAudio() || track.isVideo() {
+				return nil, readTotals(h, track)
+			}
+			return nil, nil
+		}
+		return nil, readSampleTable(h, track)
+	case mp4lib.BoxTypeStco(), mp4lib.BoxTypeCo64():
+		if !track.isText() {
+			return nil, readChunkRange(h, track)
+		}
+		return nil, readSampleTable(h, track)
+	case mp4lib.BoxTypeStsc():
+		if !track.isText() {
+			return nil, nil
+		}
+		return nil, readSampleTable(h, track)
+	default:
+		// the first entry of stsd tells the format of a text track
+		if len(h.Path) == 7 && h.Path[5] == mp4lib.BoxTypeStsd() && track.sampleEntry == (mp4lib.BoxType{}) {
+			track.sampleEntry = h.BoxInfo.Type
+		}
+	}
+	return nil, nil
+}
+
+// readSampleTable reads a sample table box of a text track.
+func readSampleTable(h *mp4lib.ReadHandle, track *trackInfo) error {
+	box, _, err := h.ReadPayload()
+	if err != nil {
+		return err
+	}
+	switch box := box.(type) {
+	case *mp4lib.Stts:
+		track.stts = box.Entries
+	case *mp4lib.Stsc:
+		track.stsc = box.Entries
+	case *mp4lib.Stsz:
+		track.sampleSize = box.SampleSize
+		track.sampleSizes = box.EntrySize
+		track.sampleCount = box.SampleCount
+	case *mp4lib.Stco:
+		track.chunks = make([]uint64, len(box.ChunkOffset))
+		for i, offset := range box.ChunkOffset {
+			track.chunks[i] = uint64(offset)
+		}
+	case *mp4lib.Co64:
+		track.chunks = box.ChunkOffset
+	}
+	return nil
+}
+
+// readTotals sums up the stts or stsz box of an audio or video track
+// without keeping its entries.
+func readTotals(h *mp4lib.ReadHandle, track *trackInfo) error {
+	buf := new(bytes.Buffer)
+	if _, err := h.ReadData(buf); err != nil {
+		return err
+	}
+	payload := buf.Bytes()
+	switch h.BoxInfo.Type {
+	case mp4lib.BoxTypeStts():
+		if len(payload) < 8 {
+			return nil
+		}
+		entries := payload[8:]
+		for i := uint32(0); i < binary.BigEndian.Uint32(payload[4:]) && len(entries) >= 8; i++ {
+			track.sampleTime += uint64(binary.BigEndian.Uint32(entries)) * uint64(binary.BigEndian.Uint32(entries[4:]))
+			entries = entries[8:]
+		}
+	case mp4lib.BoxTypeStsz():
+		if len(payload) < 12 {
+			return nil
+		}
+		track.sampleSize = binary.BigEndian.Uint32(payload[4:])
+		track.sampleCount = binary.BigEndian.Uint32(payload[8:])
+		if track.sampleSize != 0 {
+			track.mediaSize = uint64(track.sampleSize) * uint64(track.sampleCount)
+			return nil
+		}
+		for entries := payload[12:]; len(entries) >= 4; entries = entries[4:] {
+			track.mediaSize += uint64(binary.BigEndian.Uint32(entries))
+		}
+	}
+	return nil
+}
+
+// readChunkRange reads the lowest and highest offset of the stco or co64 box
+// of a track whose chunk offsets aren't kept.
+func readChunkRange(h *mp4lib.ReadHandle, track *trackInfo) error {
+	buf := new(bytes.Buffer)
+	if _, err := h.ReadData(buf); err != nil {
+		return err
+	}
+	payload := buf.Bytes()
+	if len(payload) < 8 {
+		return nil
+	}
+	size := 4
+	if h.BoxInfo.Type == mp4lib.BoxTypeCo64() {
+		size = 8
+	}
+	entries := payload[8:]
+	for i := uint32(0); i < binary.BigEndian.Uint32(payload[4:]) && len(entries) >= size; i++ {
+		offset := uint64(binary.BigEndian.Uint32(entries))
+		if size == 8 {
+			offset = binary.BigEndian.Uint64(entries)
+		}
+		if track.chunkCount == 0 || offset < track.firstChunk {
+			track.firstChunk = offset
+		}
+		if offset > track.lastChunk {
+			track.lastChunk = offset
+		}
+		track.chunkCount++
+		entries = entries[size:]
+	}
+	return nil
+}
+
+// hasChunkIn reports whether a chunk of the track starts in [start, end).
+func (t *trackInfo) hasChunkIn(start, end uint64) bool {
+	if t.isText() {
+		for _, chunk := range t.chunks {
+			if chunk >= start && chunk < end {
+				return true
+			}
+		}
+		return false
+	}
+	return t.chunkCount > 0 && t.firstChunk < end && t.lastChunk >= start
+}
+
+// track returns the track with the given ID, or nil.
+func (m *movieInfo) track(id uint32) *trackInfo {
+	for _, track := range m.tracks {
+		if track.id == id {
+			return track
+		}
+	}
+	return nil
+}
+
+// parseTrackReferences returns the track IDs of the references of the given
+// type in the payload of a tref box.
+func parseTrackReferences(payload []byte, refType mp4lib.BoxType) []uint32 {
+	var ids []uint32
+	for len(payload) >= 8 {
+		size := binary.BigEndian.Uint32(payload)
+		if size < 8 || uint64(size) > uint64(len(payload)) {
+			break
+		}
+		if (mp4lib.BoxType{payload[4], payload[5], payload[6], payload[7]}) == refType {
+			for body := payload[8:size]; len(body) >= 4; body = body[4:] {
+				ids = append(ids, binary.BigEndian.Uint32(body))
+			}
+		}
+		payload = payload[size:]
+	}
+	return ids
+}
+
+// samples lists the samples of the track from its sample tables. Tables that
+// don't add up are cut short rather than reported.
+func (t *trackInfo) samples() []sample {
+	var samples []sample
+	for i, entry := range t.stsc {
+		last := uint32(len(t.chunks))
+		if i+1 < len(t.stsc) && t.stsc[i+1].FirstChunk-1 < last {
+			last = t.stsc[i+1].FirstChunk - 1
+		}
+		for chunk := entry.FirstChunk; chunk >= 1 && chunk <= last; chunk++ {
+			offset := t.chunks[chunk-1]
+			for j := uint32(0); j < entry.SamplesPerChunk; j++ {
+				n := uint32(len(samples))
+				if n >= t.sampleCount {
+					break
+				}
+				size := t.sampleSize
+				if size == 0 {
+					if n >= uint32(len(t.sampleSizes)) {
+						break
+					}
+					size = t.sampleSizes[n]
+				}
+				samples = append(samples, sample{offset: offset, size: size})
+				offset += uint64(size)
+			}
+		}
+	}
+	var n int
+	var start uint64
+	for _, entry := range t.stts {
+		for j := uint32(0); j < entry.SampleCount && n < len(samples); j++ {
+			samples[n].start = start
+			samples[n].duration = uint64(entry.SampleDelta)
+			start += uint64(entry.SampleDelta)
+			n++
+		}
+	}
+	return samples[:n]
+}
+
+// readSample reads the data of a sample. Samples larger than limit are
+// reported as empty, text samples are never that large.
+func readSample(r io.ReadSeeker, s sample, limit uint32) ([]byte, error) {
+	if s.size > limit {
+		return nil, nil
+	}
+	if _, err := r.Seek(int64(s.offset), io.SeekStart); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, s.size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// textTrack is a timed text track added on save. All of its samples are in
+// one chunk, in an mdat of its own written after moov.
+type textTrack struct {
+	id          uint32
+	handler     [4]byte
+	name        string
+	sampleEntry mp4lib.BoxType
+	// entry is the sample entry without the reserved bytes and data
+	// reference index every sample entry starts with
+	entry     []byte
+	samples   [][]byte
+	durations []uint32
+	// chunk is the source offset given to the chunk. It is past the end of
+	// the source, so it can't clash with a real offset.
+	chunk uint64
+}
+
+// textSampleData builds a text sample, the text prefixed with its length.
+func textSampleData(text string) []byte {
+	text = truncateUTF8(text, math.MaxUint16)
+	sample := make([]byte, 2, 2+len(text))
+	binary.BigEndian.PutUint16(sample, uint16(len(text)))
+	return append(sample, text...)
+}
+
+// addSample adds a sample lasting from start to end in milliseconds. A sample
+// can't be empty, so one that ends before it starts gets a millisecond.
+func (t *textTrack) addSample(data []byte, start, end int64) {
+	if end <= start {
+		end = start + 1
+	}
+	t.samples = append(t.samples, data)
+	t.durations = append(t.durations, uint32(end-start))
+}
+
+// trackEdit adds and drops tracks while writeMoov copies the moov box. The
+// tracks of the source are identified by the offset of their trak box.
+type trackEdit struct {
+	movieTimescale uint32
+	hasUdta        bool
+	movie          *movieInfo
+	// drop holds the tracks left out of the output
+	drop        map[uint64]bool
+	tracks      []*textTrack
+	nextTrackID uint32
+	// mdatOffset is the source offset given to the mdat of the next new
+	// track
+	mdatOffset uint64
+
+	replaceChapters bool
+	chapters        []Chapter
+	// target is the track that references the new chapter track
+	target         uint64
+	targetHasTref  bool
+	chapterTrackID uint32
+}
+
+// newTrackEdit returns an edit of movie that changes nothing yet.
+func newTrackEdit(movie *movieInfo) *trackEdit {
+	edit := &trackEdit{
+		movieTimescale: movie.timescale,
+		hasUdta:        movie.hasUdta,
+		movie:          movie,
+		drop:           make(map[uint64]bool),
+		mdatOffset:     movie.fileSize,
+	}
+	for _, track := range movie.tracks {
+		if track.id >= edit.nextTrackID {
+			edit.nextTrackID = track.id + 1
+		}
+	}
+	if movie.nextTrackID > edit.nextTrackID && movie.nextTrackID != math.MaxUint32 {
+		edit.nextTrackID = movie.nextTrackID
+	}
+	return edit
+}
+
+// addTrack adds a new track and returns its ID.
+func (e *trackEdit) addTrack(track *textTrack) uint32 {
+	track.id = e.nextTrackID
+	e.nextTrackID++
+	track.chunk = e.mdatOffset + mp4lib.SmallHeaderSize
+	e.mdatOffset = track.chunk
+	for _, sample := range track.samples {
+		e.mdatOffset += uint64(len(sample))
+	}
+	e.tracks = append(e.tracks, track)
+	return track.id
+}
+
+// writeMvhd writes the mvhd box of h with room for the new track IDs.
+func (e *trackEdit) writeMvhd(w mp4Writer, h *mp4lib.ReadHandle) error {
+	box, _, err := h.ReadPayload()
+	if err != nil {
+		return err
+	}
+	mvhd := box.(*mp4lib.Mvhd)
+	if mvhd.NextTrackID < e.nextTrackID {
+		mvhd.NextTrackID = e.nextTrackID
+	}
+	return writeBox(w, mvhd, h.BoxInfo.Context)
+}
+
+// writeTracks writes the new tracks. Their chunk offset tables are added to
+// tables, with the chunk offset as their source offset.
+func (e *trackEdit) writeTracks(w mp4Writer, ctx mp4lib.Context, tables *[]chunkOffsetTable, promote map[uint64]bool) error {
+	for _, track := range e.tracks {
+		if err := e.writeTrak(w, ctx, track, tables, promote); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *trackEdit) writeTrak(w mp4Writer, ctx mp4lib.Context, track *textTrack, tables *[]chunkOffsetTable, promote map[uint64]bool) error {
+	var duration uint64
+	for _, d := range track.durations {
+		duration += uint64(d)
+	}
+	if _, err := w.StartBox(&mp4lib.BoxInfo{Type: mp4lib.BoxTypeTrak()}); err != nil {
+		return err
+	}
+	// the track is disabled, so players don't show it as subtitles
+	if err := writeBox(w, &mp4lib.Tkhd{
+		TrackID:    track.id,
+		DurationV0: uint32(duration * uint64(e.movieTimescale) / textTimescale),
+		Matrix:     [9]int32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000},
+	}, ctx); err != nil {
+		return err
+	}
+	if _, err := w.StartBox(&mp4lib.BoxInfo{Type: mp4lib.BoxTypeMdia()}); err != nil {
+		return err
+	}
+	if err := writeBox(w, &mp4lib.Mdhd{
+		Timescale:  textTimescale,
+		DurationV0: uint32(duration),
+		Language:   [3]byte{'u' - 0x60, 'n' - 0x60, 'd' - 0x60},
+	}, ctx); err != nil {
+		return err
+	}
+	if err := writeBox(w, &mp4lib.Hdlr{HandlerType: track.handler, Name: track.name}, ctx); err != nil {
+		return err
+	}
+	if _, err := w.StartBox(&mp4lib.BoxInfo{Type: mp4lib.BoxTypeMinf()}); err != nil {
+		return err
+	}
+	if err := writeRawBox(w, boxTypeNmhd, make([]byte, 4)); err != nil {
+		return err
+	}
+	if _, err := w.StartBox(&mp4lib.BoxInfo{Type: mp4lib.BoxTypeDinf()}); err != nil {
+		return err
+	}
+	if _, err := w.StartBox(&mp4lib.BoxInfo{Type: mp4lib.BoxTypeDref()}); err != nil {
+		return err
+	}
+	if _, err := mp4lib.Marshal(w, &mp4lib.Dref{EntryCount: 1}, ctx); err != nil {
+		return err
+	}
+	// the samples are in this file
+	if err := writeRawBox(w, mp4lib.BoxTypeUrl(), []byte{0, 0, 0, 1}); err != nil {
+		return err
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := w.EndBox(); err != nil {
+			return err
+		}
+	}
+	if _, err := w.StartBox(&mp4lib.BoxInfo{Type: mp4lib.BoxTypeStbl()}); err != nil {
+		return err
+	}
+	if _, err := w.StartBox(&mp4lib.BoxInfo{Type: mp4lib.BoxTypeStsd()}); err != nil {
+		return err
+	}
+	if _, err := mp4lib.Marshal(w, &mp4lib.Stsd{EntryCount: 1}, ctx); err != nil {
+		return err
+	}
+	// reserved bytes and data reference 1
+	entry := append([]byte{0, 0, 0, 0, 0, 0, 0, 1}, track.entry...)
+	if err := writeRawBox(w, track.sampleEntry, entry); err != nil {
+		return err
+	}
+	if _, err := w.EndBox(); err != nil {
+		return err
+	}
+	stts := &mp4lib.Stts{}
+	for _, d := range track.durations {
+		if n := len(stts.Entries); n > 0 && stts.Entries[n-1].SampleDelta == d {
+			stts.Entries[n-1].SampleCount++
+			continue
+		}
+		stts.Entries = append(stts.Entries, mp4lib.SttsEntry{SampleCount: 1, SampleDelta: d})
+	}
+	stts.EntryCount = uint32(len(stts.Entries))
+	stsz := &mp4lib.Stsz{SampleCount: uint32(len(track.samples))}
+	for _, sample := range track.samples {
+		stsz.EntrySize = append(stsz.EntrySize, uint32(len(sample)))
+	}
+	stsc := &mp4lib.Stsc{
+		EntryCount: 1,
+		Entries:    []mp4lib.StscEntry{{FirstChunk: 1, SamplesPerChunk: uint32(len(track.samples)), SampleDescriptionIndex: 1}},
+	}
+	for _, box := range []mp4lib.IBox{stts, stsc, stsz} {
+		if err := writeBox(w, box, ctx); err != nil {
+			return err
+		}
+	}
+	offset, _ := w.Seek(0, io.SeekCurrent)
+	*tables = append(*tables, chunkOffsetTable{srcOffset: track.chunk, dstOffset: offset})
+	var table mp4lib.IBox = &mp4lib.Stco{EntryCount: 1, ChunkOffset: []uint32{uint32(track.chunk)}}
+	if promote[track.chunk] || track.chunk > math.MaxUint32 {
+		table = &mp4lib.Co64{EntryCount: 1, ChunkOffset: []uint64{track.chunk}}
+	}
+	if err := writeBox(w, table, ctx); err != nil {
+		return err
+	}
+	// stbl, minf, mdia, trak
+	for i := 0; i < 4; i++ {
+		if _, err := w.EndBox(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropsMdat reports whether the mdat box is left out of the output. That's
+// an mdat holding samples of dropped tracks only, like the one written for
+// the old chapters, so replacing them doesn't leave their samples behind.
+// The samples of fragments aren't in the sample tables, so nothing is
+// dropped from a fragmented file.
+func (e *trackEdit) dropsMdat(info *mp4lib.BoxInfo) bool {
+	if len(e.drop) == 0 || e.movie.fragmented {
+		return false
+	}
+	start, end := info.Offset+info.HeaderSize, info.Offset+info.Size
+	var dropped bool
+	for _, track := range e.movie.tracks {
+		if !track.hasChunkIn(start, end) {
+			continue
+		}
+		if !e.drop[track.offset] {
+			return false
+		}
+		dropped = true
+	}
+	return dropped
+}
+
+// writeMdats writes an mdat box holding the samples of each new track and
+// returns them as top level boxes of the output.
+func (e *trackEdit) writeMdats(w mp4Writer) ([]topBox, error) {
+	var boxes []topBox
+	for _, track := range e.tracks {
+		if _, err := w.StartBox(&mp4lib.BoxInfo{Type: mp4lib.BoxTypeMdat()}); err != nil {
+			return nil, err
+		}
+		for _, sample := range track.samples {
+			if _, err := w.Write(sample); err != nil {
+				return nil, err
+			}
+		}
+		bi, err := w.EndBox()
+		if err != nil {
+			return nil, err
+		}
+		info := mp4lib.BoxInfo{
+			Offset:     track.chunk - mp4lib.SmallHeaderSize,
+			Size:       bi.Size,
+			HeaderSize: bi.HeaderSize,
+			Type:       mp4lib.BoxTypeMdat(),
+		}
+		boxes = append(boxes, topBox{info: info, rebuilt: true, start: bi.Offset, size: bi.Size})
+	}
+	return boxes, nil
+}
@@ -1,5 +1,10 @@
 package mp4meta
 
+import mp4lib "github.com/abema/go-mp4"
+
+// ilstPath is the location of the iTunes item list that MP4Tag maps.
+var ilstPath = mp4lib.BoxPath{mp4lib.BoxTypeMoov(), mp4lib.BoxTypeUdta(), mp4lib.BoxTypeMeta(), mp4lib.BoxTypeIlst()}
+
 func getInt(b []byte) int {
 	var n int
 	for _, x := range b {
@@ -8,3 +13,31 @@ func getInt(b []byte) int {
 	}
 	return n
 }
+
+// hasPathPrefix reports whether path begins with prefix.
+func hasPathPrefix(path, prefix mp4lib.BoxPath) bool {
+	if len(path) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// isIlstPath reports whether path is exactly the mapped ilst box.
+func isIlstPath(path mp4lib.BoxPath) bool {
+	return len(path) == len(ilstPath) && hasPathPrefix(path, ilstPath)
+}
+
+// isIlstItemPath reports whether path points at a direct child of the mapped ilst box.
+func isIlstItemPath(path mp4lib.BoxPath) bool {
+	return len(path) == len(ilstPath)+1 && hasPathPrefix(path, ilstPath)
+}
+
+// isIlstSiblingPath reports whether path points at a box in the same meta box as the mapped ilst.
+func isIlstSiblingPath(path mp4lib.BoxPath) bool {
+	return len(path) == len(ilstPath) && hasPathPrefix(path, ilstPath[:len(ilstPath)-1])
+}
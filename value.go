@@ -0,0 +1,196 @@
+package mp4meta
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"unicode/utf16"
+
+	mp4lib "github.com/abema/go-mp4"
+)
+
+// Well-known data types go-mp4 has no or wrong names for.
+const (
+	dataTypeStringUTF8Sort  = 4
+	dataTypeStringUTF16Sort = 5
+	dataTypeFloat32         = 23
+	dataTypeFloat64         = 24
+)
+
+// TextValue is a text value of an ilst item with the data type and locale of
+// its data box. Country is an ISO 3166 numeric code and Language a Macintosh
+// language code or a packed ISO 639-2/T code, both are zero by default.
+type TextValue struct {
+	Text     string
+	DataType uint32
+	Country  uint16
+	Language uint16
+}
+
+// PackLanguage packs a three letter ISO 639-2/T code, like "deu", into a
+// TextValue language.
+func PackLanguage(code string) uint16 {
+	if len(code) != 3 {
+		return 0
+	}
+	var l uint16
+	for i := 0; i < 3; i++ {
+		if code[i] < 'a' || code[i] > 'z' {
+			return 0
+		}
+		l = l<<5 | uint16(code[i]-0x60)
+	}
+	return l
+}
+
+// LanguageCode returns the ISO 639-2/T code of the language, or "" if it
+// isn't a packed code.
+func (v TextValue) LanguageCode() string {
+	if v.Language < 0x400 {
+		return ""
+	}
+	return string([]byte{
+		byte(v.Language>>10&0x1F) + 0x60,
+		byte(v.Language>>5&0x1F) + 0x60,
+		byte(v.Language&0x1F) + 0x60,
+	})
+}
+
+// GetTextValues returns the values of a text field, e.g. "Title", with
+// their data types and locales.
+func (m *MP4Tag) GetTextValues(field string) []TextValue {
+	f := reflect.ValueOf(m).Elem().FieldByName(field)
+	if !isTextField(field, f) {
+		return nil
+	}
+	return m.textValues(field, f.String())
+}
+
+// SetTextValues replaces the values of a text field, e.g. a title per
+// language. The field is set to the first value, or to all of them joined
+// for multi-valued fields like "Artist".
+func (m *MP4Tag) SetTextValues(field string, values []TextValue) error {
+	f := reflect.ValueOf(m).Elem().FieldByName(field)
+	if !isTextField(field, f) {
+		return fmt.Errorf("%s isn't a text field", field)
+	}
+	if m.values == nil {
+		m.values = map[string][]TextValue{}
+	}
+	m.values[field] = append([]TextValue(nil), values...)
+	f.SetString(joinText(field, values))
+	return nil
+}
+
+func isTextField(field string, f reflect.Value) bool {
+	if !f.IsValid() || f.Kind() != reflect.String {
+		return false
+	}
+	for _, name := range atomsMap {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+// textValues returns the values of the field. They're only used while the
+// string field still holds them, a value set since replaces them and keeps
+// the data type and locale of the first one.
+func (m *MP4Tag) textValues(field, value string) []TextValue {
+	values := m.values[field]
+	if len(values) > 0 && joinText(field, values) == value {
+		return values
+	}
+	if value == "" {
+		return nil
+	}
+	v := TextValue{Text: value, DataType: mp4lib.DataTypeStringUTF8}
+	if dataType, ok := atomDataTypes[field]; ok {
+		v.DataType = dataType
+	}
+	if len(values) > 0 {
+		v.DataType, v.Country, v.Language = values[0].DataType, values[0].Country, values[0].Language
+	}
+	return []TextValue{v}
+}
+
+// addTextValue appends a value read from a data box and returns the new
+// value of the string field.
+func (m *MP4Tag) addTextValue(field string, data *mp4lib.Data) string {
+	if m.values == nil {
+		m.values = map[string][]TextValue{}
+	}
+	m.values[field] = append(m.values[field], TextValue{
+		Text:     decodeDataText(data),
+		DataType: data.DataType,
+		Country:  uint16(data.DataLang >> 16),
+		Language: uint16(data.DataLang),
+	})
+	return joinText(field, m.values[field])
+}
+
+// joinText returns the string field holding values: all of them joined for
+// multi-valued fields, the first one otherwise.
+func joinText(field string, values []TextValue) string {
+	if len(values) == 0 {
+		return ""
+	}
+	if !multiValueFields[field] {
+		return values[0].Text
+	}
+	text := values[0].Text
+	for _, v := range values[1:] {
+		text += MultiValueSeparator + v.Text
+	}
+	return text
+}
+
+// textData returns the data box of a text value.
+func textData(v TextValue) *mp4lib.Data {
+	data := &mp4lib.Data{
+		DataType: v.DataType,
+		DataLang: uint32(v.Country)<<16 | uint32(v.Language),
+		Data:     []byte(v.Text),
+	}
+	if v.DataType == mp4lib.DataTypeStringUTF16 || v.DataType == dataTypeStringUTF16Sort {
+		u := utf16.Encode([]rune(v.Text))
+		data.Data = make([]byte, 2*len(u))
+		for i, c := range u {
+			binary.BigEndian.PutUint16(data.Data[2*i:], c)
+		}
+	}
+	return data
+}
+
+// decodeDataText decodes the text of a data box, which is UTF-8 unless its
+// type says UTF-16.
+func decodeDataText(data *mp4lib.Data) string {
+	switch data.DataType {
+	case mp4lib.DataTypeStringUTF16, dataTypeStringUTF16Sort:
+		b := data.Data
+		if len(b) >= 2 && (b[0] == 0xFE && b[1] == 0xFF || b[0] == 0xFF && b[1] == 0xFE) {
+			return decodeText(b)
+		}
+		// big endian without a byte order mark
+		u := make([]uint16, 0, len(b)/2)
+		for i := 0; i+1 < len(b); i += 2 {
+			u = append(u, binary.BigEndian.Uint16(b[i:]))
+		}
+		return string(utf16.Decode(u))
+	}
+	return string(data.Data)
+}
+
+// decodeDataInt decodes the integer of a data box, which may be stored as a
+// floating point number.
+func decodeDataInt(data *mp4lib.Data) int {
+	switch {
+	case data.DataType == dataTypeFloat32 && len(data.Data) == 4:
+		return int(math.Float32frombits(binary.BigEndian.Uint32(data.Data)))
+	case data.DataType == dataTypeFloat64 && len(data.Data) == 8:
+		return int(math.Float64frombits(binary.BigEndian.Uint64(data.Data)))
+	}
+	return getInt(data.Data)
+}
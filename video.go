@@ -0,0 +1,165 @@
+package mp4meta
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+	"time"
+
+	mp4lib "github.com/abema/go-mp4"
+)
+
+// VideoProperties describes a video track.
+type VideoProperties struct {
+	TrackID  uint32
+	Duration time.Duration
+	// Codec is "h264", "hevc", "av1", "vp9" or "mpeg4", or the sample entry
+	// type for other formats
+	Codec string
+	// Profile and Level are the profile and level indications of the codec
+	// configuration as stored, e.g. 100 and 31 for H.264 High@L3.1
+	Profile int
+	Level   int
+	// Width and Height are the coded size of the sample entry
+	Width  int
+	Height int
+	// DisplayWidth and DisplayHeight are the presentation size of the track
+	// header
+	DisplayWidth  int
+	DisplayHeight int
+	// DisplayAspectRatio takes the pixel aspect ratio into account
+	DisplayAspectRatio float64
+	FrameRate          float64
+	// Rotation is the clockwise rotation in degrees players apply, 0, 90,
+	// 180 or 270 for phone videos
+	Rotation int
+	// ColorPrimaries, TransferCharacteristics and MatrixCoefficients are the
+	// ISO/IEC 23091-2 code points of the colr box, e.g. 9, 16 and 9 for HDR10
+	ColorPrimaries          int
+	TransferCharacteristics int
+	MatrixCoefficients      int
+	FullRange               bool
+	// Bitrate is the average bitrate in bits per second
+	Bitrate int
+}
+
+// GetVideoProperties returns the properties of the video tracks in file order.
+func (m *MP4Tag) GetVideoProperties() []VideoProperties {
+	return m.video
+}
+
+// videoCodecs are the names of the video sample entry types.
+var videoCodecs = map[mp4lib.BoxType]string{
+	{'a', 'v', 'c', '1'}: "h264",
+	{'a', 'v', 'c', '3'}: "h264",
+	{'h', 'v', 'c', '1'}: "hevc",
+	{'h', 'e', 'v', '1'}: "hevc",
+	{'d', 'v', 'h', '1'}: "hevc",
+	{'d', 'v', 'h', 'e'}: "hevc",
+	{'a', 'v', '0', '1'}: "av1",
+	{'v', 'p', '0', '9'}: "vp9",
+	{'m', 'p', '4', 'v'}: "mpeg4",
+}
+
+// visualSampleEntrySize is the size of the fields of a visual sample entry,
+// unlike audio ones they have a single version.
+const visualSampleEntrySize = 70
+
+// readVideoProperties returns the properties of the video tracks of movie.
+func readVideoProperties(movie *movieInfo) []VideoProperties {
+	var videos []VideoProperties
+	for _, track := range movie.tracks {
+		if !track.isVideo() {
+			continue
+		}
+		props := VideoProperties{
+			TrackID:       track.id,
+			DisplayWidth:  int(track.width >> 16),
+			DisplayHeight: int(track.height >> 16),
+			Rotation:      matrixRotation(track.matrix),
+		}
+		if track.timescale != 0 {
+			props.Duration = mediaTime(track.duration, track.timescale)
+			// the sample durations are exact, the media duration may have
+			// an edit or padding
+			if elapsed := track.sampleTime; elapsed != 0 && track.sampleCount != 0 {
+				props.FrameRate = float64(track.sampleCount) * float64(track.timescale) / float64(elapsed)
+			} else if track.duration != 0 && track.sampleCount != 0 {
+				props.FrameRate = float64(track.sampleCount) * float64(track.timescale) / float64(track.duration)
+			}
+		}
+		hSpacing, vSpacing := 1, 1
+		if entry, ok := firstSampleEntry(track.stsd, func([]byte) int { return visualSampleEntrySize }); ok {
+			props.Codec = strings.TrimSpace(entry.boxType.String())
+			if codec, ok := videoCodecs[entry.boxType]; ok {
+				props.Codec = codec
+			}
+			props.Width = int(binary.BigEndian.Uint16(entry.fields[16:]))
+			props.Height = int(binary.BigEndian.Uint16(entry.fields[18:]))
+			props.readCodecConfig(entry.children)
+			if pasp := childBox(entry.children, mp4lib.BoxType{'p', 'a', 's', 'p'}); len(pasp) >= 8 {
+				h, v := int(binary.BigEndian.Uint32(pasp)), int(binary.BigEndian.Uint32(pasp[4:]))
+				if h != 0 && v != 0 {
+					hSpacing, vSpacing = h, v
+				}
+			}
+			props.readColr(childBox(entry.children, mp4lib.BoxType{'c', 'o', 'l', 'r'}))
+		}
+		switch {
+		case props.Width != 0 && props.Height != 0:
+			props.DisplayAspectRatio = float64(props.Width*hSpacing) / float64(props.Height*vSpacing)
+		case props.DisplayWidth != 0 && props.DisplayHeight != 0:
+			props.DisplayAspectRatio = float64(props.DisplayWidth) / float64(props.DisplayHeight)
+		}
+		if track.mediaSize > 0 && props.Duration > 0 {
+			props.Bitrate = int(float64(track.mediaSize*8) / props.Duration.Seconds())
+		}
+		videos = append(videos, props)
+	}
+	return videos
+}
+
+// readCodecConfig reads the profile and level of the codec configuration box
+// among the children of a sample entry.
+func (p *VideoProperties) readCodecConfig(children []byte) {
+	if c := childBox(children, mp4lib.BoxType{'a', 'v', 'c', 'C'}); len(c) >= 4 {
+		p.Profile = int(c[1])
+		p.Level = int(c[3])
+	} else if c := childBox(children, mp4lib.BoxType{'h', 'v', 'c', 'C'}); len(c) >= 13 {
+		p.Profile = int(c[1] & 0x1F)
+		p.Level = int(c[12])
+	} else if c := childBox(children, mp4lib.BoxType{'a', 'v', '1', 'C'}); len(c) >= 2 {
+		p.Profile = int(c[1] >> 5)
+		p.Level = int(c[1] & 0x1F)
+	} else if c := childBox(children, mp4lib.BoxType{'v', 'p', 'c', 'C'}); len(c) >= 6 {
+		// a full box
+		p.Profile = int(c[4])
+		p.Level = int(c[5])
+	}
+}
+
+// readColr reads the color description of a colr box payload. ICC profiles
+// are left out.
+func (p *VideoProperties) readColr(colr []byte) {
+	if len(colr) < 10 {
+		return
+	}
+	switch string(colr[:4]) {
+	case "nclx", "nclc":
+		p.ColorPrimaries = int(binary.BigEndian.Uint16(colr[4:]))
+		p.TransferCharacteristics = int(binary.BigEndian.Uint16(colr[6:]))
+		p.MatrixCoefficients = int(binary.BigEndian.Uint16(colr[8:]))
+		p.FullRange = string(colr[:4]) == "nclx" && len(colr) >= 11 && colr[10]&0x80 != 0
+	}
+}
+
+// matrixRotation returns the clockwise rotation of a track header matrix in
+// degrees from 0 to 359.
+func matrixRotation(matrix [9]int32) int {
+	a, b := float64(matrix[0]), float64(matrix[1])
+	if a == 0 && b == 0 {
+		return 0
+	}
+	degrees := int(math.Round(math.Atan2(b, a) * 180 / math.Pi))
+	return (degrees + 360) % 360
+}